@@ -2,44 +2,56 @@ package main
 
 import (
 	"flag"
+	"log"
+	"sync"
+	"time"
 
-	"github.com/crimsonvoid/irclib"
 	"github.com/crimsonvoid/irclib/module"
+)
+
+const (
+	confDir = "data/confs"
+	dataDir = "data"
 
-	"github.com/CrimsonVoid/ayuko/modules/choices"
-	"github.com/CrimsonVoid/ayuko/modules/magicball"
-	"github.com/CrimsonVoid/ayuko/modules/roll"
-	"github.com/crimsonvoid/ayuko/modules/fcode"
-	"github.com/crimsonvoid/ayuko/modules/reminds"
-	"github.com/crimsonvoid/ayuko/modules/url"
-	// "github.com/crimsonvoid/ayuko/modules/zen"
+	minBackoff = time.Second * 5  // First wait before reconnecting
+	maxBackoff = time.Minute * 10 // Longest wait between reconnects
 )
 
 func main() {
 	configFile := flag.String("config", "data/confs/config.toml", "Set a config file")
+	networksFile := flag.String("networks", "data/confs/networks.toml",
+		"Set a networks file; when it is missing -config is the only network")
 	flag.Parse()
 
 	module.SetLogDir("./data/logs/")
 
-	m, err := irclib.New(*configFile)
+	networks, err := loadNetworks(*networksFile)
 	if err != nil {
 		panic(err)
 	}
+	if len(networks) == 0 {
+		networks = []network{{Config: *configFile}}
+	}
 
-	m.Register(fcode.Module)
-	m.Register(reminds.Module)
-	m.Register(url.Module)
-	m.Register(roll.Module)
-	m.Register(magicball.Module)
-	m.Register(choices.Module)
-	// m.Register(zen.Module)
+	shared := &shared{}
+	var wg sync.WaitGroup
 
-	m.Connect()
+	for i := range networks {
+		net := &networks[i]
 
-	succExit := false
-	for !succExit {
-		select {
-		case succExit = <-m.Quit:
+		s, err := net.setup(shared)
+		if err != nil {
+			log.Printf("Skipping network %v: %v\n", net, err)
+			continue
 		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			net.run(s)
+		}()
 	}
+
+	wg.Wait()
 }
@@ -0,0 +1,194 @@
+package harness
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/crimsonvoid/irclib"
+	"github.com/crimsonvoid/irclib/module"
+)
+
+const (
+	connectTimeout = time.Second * 10
+
+	// ReplyTimeout is how long Say() waits for the replies it expects
+	ReplyTimeout = time.Second * 5
+	// QuietPeriod is how long Say() and Quiet() listen to check that the bot
+	// says nothing. Silence can only be observed over some period
+	QuietPeriod = time.Millisecond * 300
+
+	// Channel is the channel DefaultConf joins
+	Channel = "#test"
+
+	// DefaultConf is an irclib config template that connects to the harness
+	// and joins Channel
+	DefaultConf = `[server]
+host = "{{.Host}}"
+port = {{.Port}}
+nick = "{{.Nick}}"
+channels = ["` + Channel + `"]
+`
+)
+
+// ConfData fills in the irclib config template given to Start()
+type ConfData struct {
+	Host, Port string
+	Addr       string // Host:Port
+	Nick       string
+}
+
+// Bot is an irclib manager, with modules registered, connected to a Server
+type Bot struct {
+	Server  *Server
+	Manager *irclib.Manager
+
+	mods      []*module.Module
+	connected bool
+	dir       string // Holds the irclib config
+}
+
+// Start() runs mods against a new Server. confTemplate is a text/template of
+// an irclib config file, executed with the server's ConfData, so the bot
+// connects to the harness rather than a real network.
+//
+// Modules have already read their config and been given a data directory in
+// their New(); give each test its own so tests do not share data
+func Start(confTemplate, nick string, mods ...*module.Module) (*Bot, error) {
+	tmpl, err := template.New("conf").Parse(confTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	self := &Bot{mods: mods}
+
+	if self.dir, err = ioutil.TempDir("", "harness"); err != nil {
+		return nil, err
+	}
+
+	if self.Server, err = NewServer(); err != nil {
+		self.Stop()
+
+		return nil, err
+	}
+
+	confFile, err := os.Create(filepath.Join(self.dir, "config.toml"))
+	if err != nil {
+		self.Stop()
+
+		return nil, err
+	}
+
+	err = tmpl.Execute(confFile, ConfData{
+		Host: self.Server.Host(),
+		Port: self.Server.Port(),
+		Addr: self.Server.Addr(),
+		Nick: nick,
+	})
+	confFile.Close()
+	if err != nil {
+		self.Stop()
+
+		return nil, err
+	}
+
+	if self.Manager, err = irclib.New(confFile.Name()); err != nil {
+		self.Stop()
+
+		return nil, err
+	}
+
+	for _, mod := range mods {
+		self.Manager.Register(mod)
+	}
+
+	if err := self.Manager.Connect(); err != nil {
+		self.Stop()
+
+		return nil, err
+	}
+	self.connected = true
+
+	if err := self.Server.WaitRegistered(connectTimeout); err != nil {
+		self.Stop()
+
+		return nil, err
+	}
+
+	return self, nil
+}
+
+// StartModule() runs mod against a new Server with DefaultConf as "ayuko",
+// failing t if it cannot connect. The bot is stopped when t finishes
+func StartModule(t testing.TB, mod *module.Module) *Bot {
+	t.Helper()
+
+	bot, err := Start(DefaultConf, "ayuko", mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if err := bot.Stop(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	return bot
+}
+
+// Say() sends text to target from nick and waits up to ReplyTimeout for want
+// replies, returning ErrTimeout with those that came if there are fewer. With
+// a want of 0 it returns whatever the bot says within QuietPeriod
+func (self *Bot) Say(nick, target, text string, want int) ([]Message, error) {
+	if err := self.Server.Privmsg(nick, target, text); err != nil {
+		return nil, err
+	}
+
+	if want == 0 {
+		return self.Quiet(), nil
+	}
+
+	return self.Server.Wait(want, ReplyTimeout)
+}
+
+// Quiet() returns whatever the bot says within QuietPeriod, which should be
+// nothing when a test checks for no reply
+func (self *Bot) Quiet() []Message {
+	return self.Server.Collect(QuietPeriod)
+}
+
+// Stop() quits the bot and waits for the manager to disconnect its modules,
+// so their Disconnect has run and nothing is left running for the next test.
+// It then closes the server and removes the config
+func (self *Bot) Stop() error {
+	var err error
+
+	if self.connected && len(self.mods) > 0 {
+		self.connected = false
+		self.mods[0].Conn.Quit("harness stopped")
+
+		select {
+		case <-self.Manager.Quit:
+		case <-time.After(connectTimeout):
+			err = ErrTimeout
+		}
+	}
+
+	if self.Server != nil {
+		if closeErr := self.Server.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	if self.dir != "" {
+		if rmErr := os.RemoveAll(self.dir); rmErr != nil && err == nil {
+			err = rmErr
+		}
+	}
+
+	return err
+}
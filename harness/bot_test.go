@@ -0,0 +1,51 @@
+package harness
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/crimsonvoid/irclib/module"
+	irc "github.com/fluffle/goirc/client"
+)
+
+func TestStopDisconnects(t *testing.T) {
+	mod, err := module.New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connected, disconnected := false, false
+	mod.Preconnect = func() error {
+		connected = true
+
+		return nil
+	}
+	mod.Disconnect = func() error {
+		disconnected = true
+
+		return nil
+	}
+	mod.Register(module.E_PRIVMSG, regexp.MustCompile(`^-echo `), func(line *irc.Line) {
+		mod.Conn.Privmsg(line.Target(), line.Text()[len("-echo "):])
+	})
+
+	bot, err := Start(DefaultConf, "ayuko", mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, err := bot.Say("alice", Channel, "-echo hello", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Target != Channel || msgs[0].Text != "hello" {
+		t.Errorf("got %v; want hello echoed to %v", msgs, Channel)
+	}
+
+	if err := bot.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if !connected || !disconnected {
+		t.Errorf("Preconnect ran %v, Disconnect ran %v; want both", connected, disconnected)
+	}
+}
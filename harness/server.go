@@ -0,0 +1,391 @@
+// Package harness runs irclib modules against an in-process IRC server, so
+// their commands can be driven and their replies checked without a network
+package harness
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	serverName = "harness.local"
+	queueLen   = 256 // Bot messages buffered before the server stops reading
+)
+
+var ErrTimeout = errors.New("harness: timed out")
+
+// Message is a PRIVMSG or NOTICE sent by the bot
+type Message struct {
+	Cmd    string // PRIVMSG or NOTICE
+	Target string
+	Text   string
+}
+
+func (self Message) String() string {
+	return fmt.Sprintf("%v %v :%v", self.Cmd, self.Target, self.Text)
+}
+
+// Server is a minimal IRC server for a single client. It completes
+// registration, answers CAP, PING and JOIN, and records every PRIVMSG and
+// NOTICE the client sends
+type Server struct {
+	ln   net.Listener
+	conn net.Conn
+	nick string // The client's nick, once it has sent NICK
+	user bool   // Client has sent USER
+
+	messages   chan Message
+	raw        []string // Every line from the client
+	registered chan struct{}
+	regOnce    sync.Once
+
+	mut  sync.Mutex
+	wmut sync.Mutex
+}
+
+// NewServer() listens on a free local port and serves the first client to
+// connect, then the next after it disconnects
+func NewServer() (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	self := &Server{
+		ln:         ln,
+		messages:   make(chan Message, queueLen),
+		registered: make(chan struct{}),
+	}
+
+	go self.accept()
+
+	return self, nil
+}
+
+// Addr() returns the host:port to connect to
+func (self *Server) Addr() string {
+	return self.ln.Addr().String()
+}
+
+func (self *Server) Host() string {
+	host, _, _ := net.SplitHostPort(self.Addr())
+
+	return host
+}
+
+func (self *Server) Port() string {
+	_, port, _ := net.SplitHostPort(self.Addr())
+
+	return port
+}
+
+// Close() stops listening and drops the client
+func (self *Server) Close() error {
+	err := self.ln.Close()
+
+	self.mut.Lock()
+	if self.conn != nil {
+		self.conn.Close()
+	}
+	self.mut.Unlock()
+
+	return err
+}
+
+func (self *Server) accept() {
+	for {
+		conn, err := self.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		self.mut.Lock()
+		self.conn = conn
+		self.nick, self.user = "", false
+		self.mut.Unlock()
+
+		self.serve(conn)
+		conn.Close()
+	}
+}
+
+func (self *Server) serve(conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+
+		self.mut.Lock()
+		self.raw = append(self.raw, line)
+		self.mut.Unlock()
+
+		cmd, args := parseLine(line)
+		self.handle(cmd, args)
+
+		if cmd == "QUIT" {
+			return
+		}
+	}
+}
+
+func (self *Server) handle(cmd string, args []string) {
+	arg := func(i int) string {
+		if i < len(args) {
+			return args[i]
+		}
+		return ""
+	}
+
+	switch cmd {
+	case "NICK":
+		self.mut.Lock()
+		old, user := self.nick, self.user
+		self.nick = arg(0)
+		self.mut.Unlock()
+
+		if old != "" && self.isRegistered() {
+			self.Send(":%v!%v@%v NICK :%v", old, old, serverName, arg(0))
+		} else if user {
+			self.welcome()
+		}
+	case "USER":
+		self.mut.Lock()
+		self.user = true
+		nick := self.nick
+		self.mut.Unlock()
+
+		if nick != "" {
+			self.welcome()
+		}
+	case "CAP":
+		switch strings.ToUpper(arg(0)) {
+		case "LS":
+			self.Send(":%v CAP * LS :", serverName)
+		case "REQ":
+			self.Send(":%v CAP * ACK :%v", serverName, arg(1))
+		}
+	case "PING":
+		self.Send(":%v PONG %v :%v", serverName, serverName, arg(0))
+	case "JOIN":
+		for _, channel := range strings.Split(arg(0), ",") {
+			self.Send(":%v JOIN %v", self.prefix(), channel)
+			self.Send(":%v 353 %v = %v :%v", serverName, self.Nick(), channel, self.Nick())
+			self.Send(":%v 366 %v %v :End of /NAMES list.", serverName, self.Nick(), channel)
+		}
+	case "PART":
+		self.Send(":%v PART %v :%v", self.prefix(), arg(0), arg(1))
+	case "PRIVMSG", "NOTICE":
+		self.messages <- Message{Cmd: cmd, Target: arg(0), Text: arg(1)}
+	}
+}
+
+func (self *Server) welcome() {
+	nick := self.Nick()
+
+	self.Send(":%v 001 %v :Welcome to the harness %v", serverName, nick, nick)
+	self.Send(":%v 376 %v :End of /MOTD command.", serverName, nick)
+
+	self.regOnce.Do(func() {
+		close(self.registered)
+	})
+}
+
+func (self *Server) isRegistered() bool {
+	select {
+	case <-self.registered:
+		return true
+	default:
+		return false
+	}
+}
+
+// Nick() returns the client's nick
+func (self *Server) Nick() string {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	return self.nick
+}
+
+func (self *Server) prefix() string {
+	nick := self.Nick()
+
+	return fmt.Sprintf("%v!%v@%v", nick, nick, serverName)
+}
+
+// WaitRegistered() waits for the client to finish registering
+func (self *Server) WaitRegistered(timeout time.Duration) error {
+	select {
+	case <-self.registered:
+		return nil
+	case <-time.After(timeout):
+		return ErrTimeout
+	}
+}
+
+// Send() writes a raw line to the client
+func (self *Server) Send(format string, v ...interface{}) error {
+	self.mut.Lock()
+	conn := self.conn
+	self.mut.Unlock()
+
+	if conn == nil {
+		return errors.New("harness: no client")
+	}
+
+	self.wmut.Lock()
+	defer self.wmut.Unlock()
+
+	_, err := fmt.Fprintf(conn, format+"\r\n", v...)
+
+	return err
+}
+
+// Privmsg() sends text to target, a channel or the bot, from nick
+func (self *Server) Privmsg(nick, target, text string) error {
+	return self.Send(":%v!%v@%v PRIVMSG %v :%v", nick, nick, serverName, target, text)
+}
+
+func (self *Server) Notice(nick, target, text string) error {
+	return self.Send(":%v!%v@%v NOTICE %v :%v", nick, nick, serverName, target, text)
+}
+
+func (self *Server) Join(nick, channel string) error {
+	return self.Send(":%v!%v@%v JOIN %v", nick, nick, serverName, channel)
+}
+
+func (self *Server) Part(nick, channel, msg string) error {
+	return self.Send(":%v!%v@%v PART %v :%v", nick, nick, serverName, channel, msg)
+}
+
+func (self *Server) Kick(nick, channel, kicked, msg string) error {
+	return self.Send(":%v!%v@%v KICK %v %v :%v", nick, nick, serverName, channel, kicked, msg)
+}
+
+func (self *Server) Quit(nick, msg string) error {
+	return self.Send(":%v!%v@%v QUIT :%v", nick, nick, serverName, msg)
+}
+
+func (self *Server) NickChange(old, new string) error {
+	return self.Send(":%v!%v@%v NICK :%v", old, old, serverName, new)
+}
+
+// Next() returns the next message from the bot
+func (self *Server) Next(timeout time.Duration) (Message, error) {
+	select {
+	case msg := <-self.messages:
+		return msg, nil
+	case <-time.After(timeout):
+		return Message{}, ErrTimeout
+	}
+}
+
+// Expect() discards messages until one matches, waiting up to timeout for it
+func (self *Server) Expect(timeout time.Duration, match func(Message) bool) (Message, error) {
+	deadline := time.After(timeout)
+
+	for {
+		select {
+		case msg := <-self.messages:
+			if match(msg) {
+				return msg, nil
+			}
+		case <-deadline:
+			return Message{}, ErrTimeout
+		}
+	}
+}
+
+// Wait() returns the next n messages from the bot, or ErrTimeout with those
+// that came if there are fewer within timeout
+func (self *Server) Wait(n int, timeout time.Duration) ([]Message, error) {
+	msgs := make([]Message, 0, n)
+	deadline := time.After(timeout)
+
+	for len(msgs) < n {
+		select {
+		case msg := <-self.messages:
+			msgs = append(msgs, msg)
+		case <-deadline:
+			return msgs, ErrTimeout
+		}
+	}
+
+	return msgs, nil
+}
+
+// Collect() returns every message the bot sends until it has been quiet for
+// quiet
+func (self *Server) Collect(quiet time.Duration) []Message {
+	msgs := make([]Message, 0, 4)
+
+	for {
+		select {
+		case msg := <-self.messages:
+			msgs = append(msgs, msg)
+		case <-time.After(quiet):
+			return msgs
+		}
+	}
+}
+
+// Raw() returns every line the client has sent
+func (self *Server) Raw() []string {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	return append([]string{}, self.raw...)
+}
+
+// To() matches messages sent to target
+func To(target string) func(Message) bool {
+	return func(msg Message) bool {
+		return strings.EqualFold(msg.Target, target)
+	}
+}
+
+// Containing() matches messages to target whose text contains text
+func Containing(target, text string) func(Message) bool {
+	return func(msg Message) bool {
+		return strings.EqualFold(msg.Target, target) && strings.Contains(msg.Text, text)
+	}
+}
+
+// parseLine() splits a raw line into its command and arguments, dropping any
+// tags and prefix
+func parseLine(line string) (string, []string) {
+	if strings.HasPrefix(line, "@") {
+		if i := strings.Index(line, " "); i >= 0 {
+			line = line[i+1:]
+		}
+	}
+	if strings.HasPrefix(line, ":") {
+		if i := strings.Index(line, " "); i >= 0 {
+			line = line[i+1:]
+		}
+	}
+
+	trailing, hasTrailing := "", false
+	if i := strings.Index(line, " :"); i >= 0 {
+		trailing, hasTrailing = line[i+2:], true
+		line = line[:i]
+	}
+
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return "", nil
+	}
+	if hasTrailing {
+		args = append(args, trailing)
+	}
+
+	return strings.ToUpper(args[0]), args[1:]
+}
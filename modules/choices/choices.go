@@ -1,24 +1,24 @@
 package choices
 
 import (
-	"fmt"
-	"path/filepath"
-	"runtime"
+	"math/rand"
+	"time"
 
 	"github.com/crimsonvoid/irclib/module"
 )
 
-func init() {
-	_, file, _, _ := runtime.Caller(0)
-	base := filepath.Base(file)
-	ext := filepath.Ext(base)
-	var err error
-
-	Module, err = module.New(fmt.Sprintf("data%[1]cconfs%[1]c%v.toml",
-		filepath.Separator, base[:len(base)-len(ext)]))
+// New() creates a choices module configured by confFile
+func New(confFile string) (*Module, error) {
+	mod, err := module.New(confFile)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	self := &Module{
+		Module: mod,
+		rng:    rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
+	self.registerCommands()
 
-	registerCommands()
+	return self, nil
 }
@@ -0,0 +1,40 @@
+package choices
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/crimsonvoid/ayuko/harness"
+)
+
+func TestPick(t *testing.T) {
+	mod, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bot := harness.StartModule(t, mod.Module)
+
+	for text, choices := range map[string][]string{
+		"-pick tea, coffee or water": {"tea", "coffee", "water"},
+		".pick left OR right":        {"left", "right"},
+		"-pick only this":            {"only this"},
+	} {
+		msgs, err := bot.Say("alice", harness.Channel, text, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != 1 || msgs[0].Target != harness.Channel {
+			t.Fatalf("%q: got %v; want one reply in %v", text, msgs, harness.Channel)
+		}
+
+		picked := strings.TrimPrefix(msgs[0].Text, "alice, ")
+		found := false
+		for _, choice := range choices {
+			found = found || picked == choice
+		}
+		if !found || picked == msgs[0].Text {
+			t.Errorf("%q: got %q; want alice, one of %v", text, msgs[0].Text, choices)
+		}
+	}
+}
@@ -9,14 +9,14 @@ import (
 	irc "github.com/fluffle/goirc/client"
 )
 
-func registerCommands() {
-	regChoices()
+func (self *Module) registerCommands() {
+	self.regChoices()
 }
 
-func regChoices() {
+func (self *Module) regChoices() {
 	re := regexp.MustCompile(`^(-|\.)pick (?P<choice>.*)`)
 
-	Module.Register(module.E_PRIVMSG, re, func(line *irc.Line) {
+	self.Register(module.E_PRIVMSG, re, func(line *irc.Line) {
 		res := re.FindStringSubmatch(line.Text())
 
 		choices := []string{}
@@ -25,9 +25,9 @@ func regChoices() {
 				choices = append(choices, strings.Split(c, " OR ")...)
 			}
 		}
-		index := rng.Intn(len(choices))
+		index := self.rng.Intn(len(choices))
 
-		Module.Conn.Privmsg(
+		self.Conn.Privmsg(
 			line.Target(),
 			fmt.Sprintf("%v, %v", line.Nick, strings.TrimSpace(choices[index])))
 	})
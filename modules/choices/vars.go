@@ -2,12 +2,13 @@ package choices
 
 import (
 	"math/rand"
-	"time"
 
 	"github.com/crimsonvoid/irclib/module"
 )
 
-var (
-	Module *module.Module
-	rng    = rand.New(rand.NewSource(time.Now().UnixNano()))
-)
+// Module is one instance of the choices module
+type Module struct {
+	*module.Module
+
+	rng *rand.Rand
+}
@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"log"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/crimsonvoid/ayuko/modules/store"
 	"github.com/crimsonvoid/irclib/module"
 	"github.com/crimsonvoid/irclib/styles"
 	irc "github.com/fluffle/goirc/client"
@@ -15,20 +18,38 @@ func init() {
 	log.SetFlags(0)
 }
 
-func registerCommands() {
-	Module.Preconnect = fCodes.Start
-	Module.Disconnect = fCodes.Exit
+func (self *Module) registerCommands() {
+	self.Preconnect = func() error {
+		self.members.Clear()
+		self.events.Attach(self.Conn)
 
-	regComAdd()
-	regComRem()
-	regComGet()
-	regComGetSystem()
-	regComFcHelp()
+		return self.fCodes.Open()
+	}
+	self.Disconnect = func() error {
+		self.events.Detach()
+		self.members.Clear()
+
+		return self.fCodes.Close()
+	}
+
+	self.regComAdd()
+	self.regComRem()
+	self.regComGet()
+	self.regComGetSystem()
+	self.regComFcHelp()
+	self.regComPrivacy()
+	self.regComContinue()
+	self.regComSearch()
+	self.regEvMembers()
 
 	errFns := []func() error{
-		regConsSave,
-		regConsLoad,
-		regConsList,
+		self.regConsSave,
+		self.regConsLoad,
+		self.regConsList,
+		self.regConsSnapshots,
+		self.regConsRestore,
+		self.regConsExport,
+		self.regConsImport,
 	}
 
 	for _, errFn := range errFns {
@@ -38,134 +59,327 @@ func registerCommands() {
 	}
 }
 
-func regComAdd() {
-	Module.Register(module.E_PRIVMSG, fcAdd, func(line *irc.Line) {
-		lineText := line.Text()
-		groups, _ := matchGroups(fcAdd, lineText)
+func (self *Module) regComAdd() {
+	self.Register(module.E_PRIVMSG, fcAdd, func(line *irc.Line) {
+		groups, _ := matchGroups(fcAdd, line.Text())
 
-		groups["system"] = strings.ToLower(groups["system"])
+		// Steam codes may be resolved through the Steam Web API
+		go self.addCode(line, self.ids.Resolve(line.Nick), groups["system"], groups["fcode"])
+	})
+}
 
-		ok := fCodes.Add(strings.ToLower(line.Nick), groups["system"], groups["fcode"])
-		if !ok {
-			Module.Logger.Errorf("Add(%v, %v, %v)\n  Line: %v\n",
-				strings.ToLower(line.Nick), groups["system"], groups["fcode"], lineText)
-			Module.Conn.Notice(line.Nick, "There was a problem adding you.")
+// addCode() saves code for nick and replies to line with the result
+func (self *Module) addCode(line *irc.Line, nick, system, fcode string) {
+	code, err := self.fCodes.Add(nick, system, fcode)
+	if err != nil {
+		self.Logger.Errorf("Add(%v, %v, %v): %v\n  Line: %v\n",
+			nick, system, fcode, err, line.Text())
+		self.Conn.Notice(line.Nick, fmt.Sprintf("There was a problem adding you: %v", err))
 
-			return
-		}
+		return
+	}
 
-		Module.Logger.Infof("Added friendCode[%v].%v = %v\n",
-			strings.ToLower(line.Nick), groups["system"], groups["fcode"])
-		Module.Conn.Notice(line.Nick,
-			fmt.Sprintf("Saved friend code %v for %v\n", groups["fcode"], groups["system"]))
-	})
+	self.Logger.Infof("Added friendCode[%v].%v = %v\n", nick, system, code)
+	self.Conn.Notice(line.Nick, fmt.Sprintf("Saved friend code %v for %v\n", code, system))
 }
 
-func regComRem() {
-	Module.Register(module.E_PRIVMSG, fcRem, func(line *irc.Line) {
+func (self *Module) regComRem() {
+	self.Register(module.E_PRIVMSG, fcRem, func(line *irc.Line) {
 		lineText := line.Text()
 		groups, _ := matchGroups(fcRem, lineText)
 
 		groups["system"] = strings.ToLower(groups["system"])
+		nick := self.ids.Resolve(line.Nick)
 
-		err := fCodes.Remove(strings.ToLower(line.Nick), groups["system"])
+		err := self.fCodes.Remove(nick, groups["system"])
 		if err != nil {
-			Module.Logger.Errorf("Remove(%v, %v) error: %v\n  Line: %v\n",
-				strings.ToLower(line.Nick), groups["system"], err, lineText)
-			Module.Conn.Notice(line.Nick, err.Error())
+			self.Logger.Errorf("Remove(%v, %v) error: %v\n  Line: %v\n",
+				nick, groups["system"], err, lineText)
+			self.Conn.Notice(line.Nick, err.Error())
 
 			return
 		}
 
 		switch groups["system"] {
 		case "*":
-			Module.Logger.Infof("Deleted friendCode[%v]\n", groups["nick"])
-			Module.Conn.Notice(line.Nick, "Removed you from the database")
+			self.Logger.Infof("Deleted friendCode[%v]\n", nick)
+			self.Conn.Notice(line.Nick, "Removed you from the database")
 		default:
-			Module.Logger.Infof("Removed friendCode[%v].%v\n", groups["nick"], groups["system"])
-			Module.Conn.Notice(line.Nick, fmt.Sprintf("Removed nick for %s", groups["system"]))
+			self.Logger.Infof("Removed friendCode[%v].%v\n", nick, groups["system"])
+			self.Conn.Notice(line.Nick, fmt.Sprintf("Removed nick for %s", groups["system"]))
 		}
 	})
 }
 
-func regComGet() {
-	Module.Register(module.E_PRIVMSG, fcGet, func(line *irc.Line) {
+func (self *Module) regComGet() {
+	self.Register(module.E_PRIVMSG, fcGet, func(line *irc.Line) {
 		lineText := strings.ToLower(line.Text())
+		if fcPrivacy.MatchString(lineText) || fcContinue.MatchString(lineText) {
+			return
+		}
+
 		groups, _ := matchGroups(fcGet, lineText)
+		v := self.viewerOf(line, groups["mode"])
+
+		owner, ok := self.fCodes.Find(self.ids, groups["nick"])
+		if !ok {
+			reply := fmt.Sprintf("Sorry I could not find %v in the database", groups["nick"])
+			if similar := self.fCodes.Suggest(self.ids, groups["nick"], v); len(similar) > 0 {
+				reply += fmt.Sprintf(". Did you mean %v?", strings.Join(similar, ", "))
+			}
+			self.Conn.Notice(line.Nick, reply)
+
+			return
+		}
 
-		fcMap, err := fCodes.GetUser(groups["nick"])
+		fcMap, err := self.fCodes.GetUser(owner, v)
 		if err != nil {
-			Module.Logger.Errorf("GetUser(%v): %v\n  Line: %v\n",
-				groups["nick"], err, lineText)
-			Module.Conn.Notice(line.Nick,
+			self.Logger.Errorf("GetUser(%v): %v\n  Line: %v\n",
+				owner, err, lineText)
+			self.Conn.Notice(line.Nick,
 				fmt.Sprintf("Sorry I could not find %v in the database", groups["nick"]))
 
 			return
 		}
+		if len(fcMap) == 0 {
+			self.Conn.Notice(line.Nick,
+				fmt.Sprintf("%v has not shared any friend codes with you", groups["nick"]))
+
+			return
+		}
+
+		// Steam profiles may need looking up; reply without holding up the
+		// connection
+		go self.replyCodes(line, groups["nick"], groups["mode"], fcMap)
+	})
+}
+
+// replyCodes() sends nick's codes in fcMap, describing Steam profiles
+func (self *Module) replyCodes(line *irc.Line, nick, mode string, fcMap map[string]string) {
+	codes := fmt.Sprintf("%v's friend codes are ", nick)
+	for _, sys := range self.fCodes.registry.Systems() {
+		if code, ok := fcMap[sys.Name]; ok {
+			if sys.Key == steamKey {
+				var err error
+				if code, err = self.fCodes.steam.Describe(code); err != nil {
+					self.Logger.Errorf("Steam profile %v: %v\n", code, err)
+				}
+			}
 
-		codes := fmt.Sprintf("%v's friend codes are ", groups["nick"])
-		for system, code := range fcMap {
 			codes += fmt.Sprintf("(%v: %v) ",
-				styles.Bold.Paint("%v", system),
+				styles.Bold.Paint("%v", sys.Name),
 				styles.LightBlue.Fg("%v", code))
 		}
+	}
 
-		switch groups["mode"] {
-		case PRIV:
-			Module.Conn.Notice(line.Nick, codes)
-		case PUBLIC:
-			Module.Conn.Privmsg(line.Target(), codes)
-		}
-	})
+	switch mode {
+	case PRIV:
+		self.Conn.Notice(line.Nick, codes)
+	case PUBLIC:
+		self.Conn.Privmsg(line.Target(), codes)
+	}
 }
 
-func regComGetSystem() {
-	Module.Register(module.E_PRIVMSG, fcList, func(line *irc.Line) {
+func (self *Module) regComGetSystem() {
+	self.Register(module.E_PRIVMSG, fcList, func(line *irc.Line) {
 		lineText := line.Text()
 		groups, _ := matchGroups(fcList, lineText)
 
-		groups["system"] = strings.ToLower(groups["system"])
+		if _, ok := self.fCodes.registry.Lookup(groups["system"]); !ok {
+			self.Conn.Notice(line.Nick, fmt.Sprintf("I don't know the system %v", groups["system"]))
 
-		sysMap := fCodes.GetSystem(groups["system"])
+			return
+		}
+
+		sysMap := self.fCodes.GetSystem(groups["system"], self.viewerOf(line, groups["mode"]))
 		if len(sysMap) == 0 {
-			Module.Conn.Notice(line.Nick,
+			self.Conn.Notice(line.Nick,
 				fmt.Sprintf("No one has saved any codes for %v :<", groups["system"]))
+
+			return
 		}
 
-		codes := ""
-		for nick, code := range sysMap {
-			codes += fmt.Sprintf("(%v: %v) ",
+		nicks := make([]string, 0, len(sysMap))
+		for nick := range sysMap {
+			nicks = append(nicks, nick)
+		}
+		sort.Strings(nicks)
+
+		codes := make([]string, 0, len(nicks))
+		for _, nick := range nicks {
+			codes = append(codes, fmt.Sprintf("(%v: %v)",
 				styles.Bold.Paint("%v", nick),
-				styles.LightBlue.Fg("%v", code))
+				styles.LightBlue.Fg("%v", sysMap[nick])))
 		}
 
+		out := page{Lines: splitLines(codes, " ", maxLineLen)}
 		switch groups["mode"] {
 		case PRIV:
-			Module.Conn.Notice(line.Nick, codes)
+			out.Target, out.Notice = line.Nick, true
 		case PUBLIC:
-			Module.Conn.Privmsg(line.Target(), codes)
+			out.Target = line.Target()
+		}
+
+		n := 1
+		if groups["page"] != "" {
+			n, _ = strconv.Atoi(groups["page"])
+		}
+
+		if err := self.pages.Send(line.Nick, out, n); err != nil {
+			self.Conn.Notice(line.Nick, fmt.Sprintf("I'm sorry, %v", err))
+		}
+	})
+}
+
+func (self *Module) regComSearch() {
+	self.Register(module.E_PRIVMSG, fcSearch, func(line *irc.Line) {
+		groups, _ := matchGroups(fcSearch, line.Text())
+
+		found := self.fCodes.Search(self.ids, groups["query"], self.viewerOf(line, groups["mode"]))
+		if len(found) == 0 {
+			self.Conn.Notice(line.Nick, fmt.Sprintf("No one matching %v has saved any codes", groups["query"]))
+
+			return
+		}
+
+		out := page{Lines: splitLines(found, ", ", maxLineLen)}
+		switch groups["mode"] {
+		case PRIV:
+			out.Target, out.Notice = line.Nick, true
+		case PUBLIC:
+			out.Target = line.Target()
+		}
+
+		if err := self.pages.Send(line.Nick, out, 1); err != nil {
+			self.Conn.Notice(line.Nick, fmt.Sprintf("I'm sorry, %v", err))
+		}
+	})
+}
+
+func (self *Module) regComContinue() {
+	self.Register(module.E_PRIVMSG, fcContinue, func(line *irc.Line) {
+		if !self.pages.Continue(line.Nick) {
+			self.Conn.Notice(line.Nick, "There is nothing more to show you")
+		}
+	})
+}
+
+func (self *Module) regComPrivacy() {
+	self.Register(module.E_PRIVMSG, fcPrivacy, func(line *irc.Line) {
+		groups, _ := matchGroups(fcPrivacy, line.Text())
+		nick := self.ids.Resolve(line.Nick)
+
+		if groups["level"] == "" {
+			self.Conn.Notice(line.Nick, "Your friend code privacy is "+self.fCodes.Privacy(nick))
+
+			return
+		}
+
+		allow := strings.FieldsFunc(groups["nicks"], func(r rune) bool {
+			return r == ' ' || r == ','
+		})
+		for i := range allow {
+			allow[i] = self.ids.Resolve(allow[i])
+		}
+
+		err := self.fCodes.SetPrivacy(nick, groups["system"], groups["level"], allow)
+		if err != nil {
+			self.Conn.Notice(line.Nick, fmt.Sprintf("I'm sorry, %v", err))
+
+			return
+		}
+
+		self.Logger.Infof("Set privacy[%v].%v = %v %v\n",
+			nick, groups["system"], groups["level"], allow)
+		self.Conn.Notice(line.Nick, "Your friend code privacy is now "+self.fCodes.Privacy(nick))
+	})
+}
+
+// regEvMembers() tracks who is in each channel for visChannel, starting with
+// the NAMES of every channel joined
+func (self *Module) regEvMembers() {
+	re := regexp.MustCompile(`.*`)
+
+	self.events.Handle("353", func(line *irc.Line) {
+		// RPL_NAMREPLY: me = #channel :nick @op +voice
+		if len(line.Args) < 4 {
+			return
+		}
+
+		for _, nick := range strings.Fields(line.Text()) {
+			if nick = strings.TrimLeft(nick, namesPrefixes); nick != "" {
+				self.members.Join(line.Args[2], nick)
+			}
+		}
+	})
+	self.events.Handle("JOIN", func(line *irc.Line) {
+		self.members.Join(line.Target(), line.Nick)
+	})
+	self.Register(module.E_PRIVMSG, re, func(line *irc.Line) {
+		if line.Public() {
+			self.members.Join(line.Target(), line.Nick)
+		}
+	})
+	self.events.Handle("PART", func(line *irc.Line) {
+		self.parted(line.Target(), line.Nick)
+	})
+	self.events.Handle("KICK", func(line *irc.Line) {
+		if len(line.Args) >= 2 {
+			self.parted(line.Args[0], line.Args[1])
+		}
+	})
+	self.events.Handle("QUIT", func(line *irc.Line) {
+		self.members.Quit(line.Nick)
+	})
+	self.events.Handle("NICK", func(line *irc.Line) {
+		if len(line.Args) > 0 {
+			self.members.Rename(line.Nick, line.Args[0])
 		}
 	})
 }
 
-func regComFcHelp() {
-	Module.Register(module.E_PRIVMSG, fcHelp, func(line *irc.Line) {
-		Module.Conn.Notice(line.Nick, FcHelp())
+// parted() forgets nick in channel, or the whole channel if the bot left it
+func (self *Module) parted(channel, nick string) {
+	if self.isMe(nick) {
+		self.members.Leave(channel)
+	} else {
+		self.members.Part(channel, nick)
+	}
+}
+
+// viewerOf() returns who will see the reply to a lookup made in line. Public
+// replies are seen by the whole channel
+func (self *Module) viewerOf(line *irc.Line, mode string) *viewer {
+	v := &viewer{members: self.members}
+
+	if line.Public() {
+		v.Channel = line.Target()
+	}
+	if mode == PRIV || !line.Public() {
+		v.Nick = self.ids.Resolve(line.Nick)
+	}
+
+	return v
+}
+
+func (self *Module) regComFcHelp() {
+	self.Register(module.E_PRIVMSG, fcHelp, func(line *irc.Line) {
+		self.Conn.Notice(line.Nick, self.FcHelp())
 	})
 }
 
-func regConsSave() error {
+func (self *Module) regConsSave() error {
 	re := regexp.MustCompile(`^save ?(?P<file>.*)?$`)
-	err := Module.Console.Register(re, func(s string) {
+	err := self.Console.Register(re, func(s string) {
 		groups, _ := matchGroups(re, s)
 
 		if groups["file"] == "" {
 			groups["file"] = "codes.gob"
 		}
 
-		if err := fCodes.Save(groups["file"]); err != nil {
+		if err := self.fCodes.Save(groups["file"]); err != nil {
 			errMsg := fmt.Sprintf("Error saving %v: %v", groups["file"], err)
-			Module.Logger.Errorln(errMsg)
+			self.Logger.Errorln(errMsg)
 			log.Println(errMsg)
 
 			return
@@ -177,18 +391,18 @@ func regConsSave() error {
 	return err
 }
 
-func regConsLoad() error {
+func (self *Module) regConsLoad() error {
 	re := regexp.MustCompile(`^load ?(?P<file>.*)$`)
-	err := Module.Console.Register(re, func(s string) {
+	err := self.Console.Register(re, func(s string) {
 		groups, _ := matchGroups(re, s)
 
 		if groups["file"] == "" {
 			groups["file"] = "codes.gob"
 		}
 
-		if err := fCodes.Load(groups["file"]); err != nil {
+		if err := self.fCodes.Load(groups["file"]); err != nil {
 			errMsg := fmt.Sprintf("Error loading %v: %v", groups["file"], err)
-			Module.Logger.Errorln(errMsg)
+			self.Logger.Errorln(errMsg)
 			log.Println(errMsg)
 
 			return
@@ -200,9 +414,97 @@ func regConsLoad() error {
 	return err
 }
 
-func regConsList() error {
-	err := Module.Console.Register("list", func(s string) {
-		log.Print(fCodes.String())
+func (self *Module) regConsList() error {
+	re := regexp.MustCompile(`^list( (?P<redact>redact))?$`)
+	err := self.Console.Register(re, func(s string) {
+		groups, _ := matchGroups(re, s)
+
+		if groups["redact"] != "" {
+			log.Print(self.fCodes.format(&viewer{}))
+
+			return
+		}
+
+		log.Print(self.fCodes.String())
+	})
+
+	return err
+}
+
+func (self *Module) regConsSnapshots() error {
+	err := self.Console.Register("snapshots", func(string) {
+		snaps, err := self.fCodes.snapshots.List()
+		if err != nil {
+			log.Printf("Error listing snapshots: %v\n", err)
+
+			return
+		}
+
+		for _, snap := range snaps {
+			log.Println(snap.Name)
+		}
+	})
+
+	return err
+}
+
+func (self *Module) regConsRestore() error {
+	re := regexp.MustCompile(`^restore (?P<file>.+)$`)
+	err := self.Console.Register(re, func(s string) {
+		groups, _ := matchGroups(re, s)
+
+		if err := self.fCodes.snapshots.Restore(groups["file"]); err != nil {
+			errMsg := fmt.Sprintf("Error restoring %v: %v", groups["file"], err)
+			self.Logger.Errorln(errMsg)
+			log.Println(errMsg)
+
+			return
+		}
+
+		log.Printf("Restored codes from %v\n", groups["file"])
+	})
+
+	return err
+}
+
+func (self *Module) regConsExport() error {
+	re := regexp.MustCompile(`^export (?P<file>\S+)( (?P<redact>redact))?$`)
+	err := self.Console.Register(re, func(s string) {
+		groups, _ := matchGroups(re, s)
+
+		if err := self.fCodes.Export(groups["file"], groups["redact"] != ""); err != nil {
+			errMsg := fmt.Sprintf("Error exporting %v: %v", groups["file"], err)
+			self.Logger.Errorln(errMsg)
+			log.Println(errMsg)
+
+			return
+		}
+
+		log.Printf("Exported codes to %v\n", groups["file"])
+	})
+
+	return err
+}
+
+func (self *Module) regConsImport() error {
+	re := regexp.MustCompile(`^import (?P<file>\S+)( (?P<mode>merge|replace))?( (?P<dry>dry))?$`)
+	err := self.Console.Register(re, func(s string) {
+		groups, _ := matchGroups(re, s)
+
+		if groups["mode"] == "" {
+			groups["mode"] = store.Merge
+		}
+
+		report, err := self.fCodes.Import(self.ids, groups["file"], groups["mode"], groups["dry"] != "")
+		if err != nil {
+			errMsg := fmt.Sprintf("Error importing %v: %v", groups["file"], err)
+			self.Logger.Errorln(errMsg)
+			log.Println(errMsg)
+
+			return
+		}
+
+		log.Println(report)
 	})
 
 	return err
@@ -0,0 +1,43 @@
+package fcode
+
+import (
+	"os"
+
+	"github.com/BurntSushi/toml"
+	"github.com/crimsonvoid/ayuko/modules/store"
+)
+
+type config struct {
+	Fcode struct {
+		store.SnapshotConfig
+
+		// [[fcode.systems]] tables add systems, or replace the default
+		// system with the same key
+		Systems []System `toml:"systems"`
+
+		// [fcode.steam] resolves Steam vanity names and shows profiles
+		Steam struct {
+			Key string `toml:"key"` // Steam Web API key
+			Api string `toml:"api"` // Base URL, defaults to steamAPI
+		} `toml:"steam"`
+	}
+}
+
+func (self *fcManager) loadConfig(fileName string) error {
+	conf := config{}
+
+	if _, err := toml.DecodeFile(fileName, &conf); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	systems := append(append([]System{}, defaultSystems...), conf.Fcode.Systems...)
+
+	var err error
+	if self.registry, err = NewRegistry(systems); err != nil {
+		return err
+	}
+
+	self.steam = newSteamClient(conf.Fcode.Steam.Key, conf.Fcode.Steam.Api)
+
+	return conf.Fcode.Apply(self.snapshots)
+}
@@ -0,0 +1,206 @@
+package fcode
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/crimsonvoid/ayuko/modules/identity"
+	"github.com/crimsonvoid/ayuko/modules/store"
+)
+
+const exportVersion = 1
+
+// exportFile is the JSON export format. Version 1:
+//
+//	{
+//	  "version": 1,
+//	  "codes": {
+//	    "<nick>": {"<system>": "<code>", ...},
+//	    ...
+//	  }
+//	}
+//
+// Systems are System keys or aliases and codes must pass the same checks as
+// `.fcode add`. Steam vanity names are resolved to SteamID64s the same way.
+type exportFile struct {
+	Version int                          `json:"version"`
+	Codes   map[string]map[string]string `json:"codes"`
+}
+
+// Export() writes every code to fileName as JSON. With redact only codes
+// anyone may see are written
+func (self *fcManager) Export(fileName string, redact bool) error {
+	self.mut.RLock()
+	defer self.mut.RUnlock()
+
+	export := exportFile{
+		Version: exportVersion,
+		Codes:   make(map[string]map[string]string, len(self.friendCodes)),
+	}
+
+	var v *viewer
+	if redact {
+		v = &viewer{}
+	}
+
+	for nick, fCode := range self.friendCodes {
+		codes := make(map[string]string, len(fCode))
+		for key, code := range fCode {
+			if self.visible(nick, key, v) {
+				codes[key] = code
+			}
+		}
+
+		if len(codes) > 0 {
+			export.Codes[nick] = codes
+		}
+	}
+
+	return store.WriteFile(self.dataDir+fileName, func(w io.Writer) error {
+		data, err := json.MarshalIndent(export, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(data)
+
+		return err
+	})
+}
+
+// Import() reads codes exported by Export(). In store.Merge mode imported
+// codes are added to the existing ones, replacing any they conflict with.
+// In store.Replace mode only the imported codes are kept. Invalid codes are
+// skipped. Nicks are resolved through ids. With dryRun nothing is changed
+func (self *fcManager) Import(ids *identity.Module, fileName, mode string, dryRun bool) (*store.ImportReport, error) {
+	data, err := ioutil.ReadFile(self.dataDir + fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	export := exportFile{}
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, err
+	}
+	if export.Version < 1 || export.Version > exportVersion {
+		return nil, fmt.Errorf("Unsupported export version %v", export.Version)
+	}
+
+	report := &store.ImportReport{Mode: mode, DryRun: dryRun}
+
+	// Codes are normalized like Add() does, before locking, since resolving
+	// Steam vanity names calls the Steam Web API
+	imported := make(map[string]friendCode, len(export.Codes))
+
+	for _, rawNick := range sortedNicks(export.Codes) {
+		nick := ids.Resolve(rawNick)
+
+		fCode, ok := imported[nick]
+		if !ok {
+			fCode = make(friendCode)
+			imported[nick] = fCode
+		}
+
+		for system, code := range export.Codes[rawNick] {
+			sys, ok := self.registry.Lookup(system)
+			if !ok {
+				report.Reject("%v.%v: unknown system", rawNick, system)
+				continue
+			}
+
+			code, err := self.normalize(sys, code)
+			if err != nil {
+				report.Reject("%v.%v: %v", rawNick, system, err)
+				continue
+			}
+
+			fCode[sys.Key] = code
+		}
+	}
+
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	nicks := make([]string, 0, len(self.friendCodes)+len(imported))
+	for nick := range self.friendCodes {
+		nicks = append(nicks, nick)
+	}
+	for nick := range imported {
+		if _, ok := self.friendCodes[nick]; !ok {
+			nicks = append(nicks, nick)
+		}
+	}
+	sort.Strings(nicks)
+
+	friendCodes := make(map[string]friendCode, len(nicks))
+
+	for _, nick := range nicks {
+		oldCode, newCode := self.friendCodes[nick], imported[nick]
+
+		fCode := make(friendCode)
+		if mode == store.Merge {
+			for key, code := range oldCode {
+				fCode[key] = code
+			}
+		}
+
+		for _, key := range self.registry.Keys() {
+			old, code := oldCode[key], newCode[key]
+
+			switch {
+			case code == "":
+				if old != "" && mode == store.Replace {
+					report.Removed++
+				}
+
+				continue
+			case old == "":
+				report.Added++
+			case old == code:
+				report.Unchanged++
+			default:
+				report.Conflict("%v.%v: %q -> %q", nick, key, old, code)
+				report.Updated++
+			}
+
+			fCode[key] = code
+		}
+
+		if len(fCode) > 0 {
+			friendCodes[nick] = fCode
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+	if self.db == nil {
+		return nil, store.ErrClosed
+	}
+
+	stored := make(map[string]interface{}, len(friendCodes))
+	for nick, fCode := range friendCodes {
+		stored[nick] = fCode
+	}
+
+	if err := self.db.Replace(codesBucket, stored); err != nil {
+		return nil, err
+	}
+	self.friendCodes = friendCodes
+	self.snapshots.Changed()
+
+	return report, nil
+}
+
+func sortedNicks(codes map[string]map[string]string) []string {
+	nicks := make([]string, 0, len(codes))
+	for nick := range codes {
+		nicks = append(nicks, nick)
+	}
+	sort.Strings(nicks)
+
+	return nicks
+}
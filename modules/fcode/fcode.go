@@ -1,24 +1,59 @@
 package fcode
 
 import (
-	"fmt"
-	"path/filepath"
-	"runtime"
-
+	"github.com/crimsonvoid/ayuko/modules/identity"
+	"github.com/crimsonvoid/ayuko/modules/store"
 	"github.com/crimsonvoid/irclib/module"
 )
 
-func init() {
-	_, file, _, _ := runtime.Caller(0)
-	base := filepath.Base(file)
-	ext := filepath.Ext(base)
-	var err error
+// New() creates an fcode module configured by confFile that keeps its data
+// in dataDir, or defaultDataDir if it is empty. Nicks are resolved through
+// ids
+func New(confFile, dataDir string, ids *identity.Module) (*Module, error) {
+	mod, err := module.New(confFile)
+	if err != nil {
+		return nil, err
+	}
+
+	self := newModule(mod, ids, nil)
+	self.fCodes = NewfcManager(store.DataDir(dataDir, defaultDataDir),
+		func(format string, v ...interface{}) {
+			self.Logger.Errorf(format, v...)
+		})
 
-	Module, err = module.New(fmt.Sprintf("data%[1]cconfs%[1]c%v.toml",
-		filepath.Separator, base[:len(base)-len(ext)]))
+	if err := self.fCodes.loadConfig(confFile); err != nil {
+		return nil, err
+	}
+
+	self.registerCommands()
+
+	return self, nil
+}
+
+// Share() creates an fcode module for another network, configured by
+// confFile, that shares self's friend codes, systems and snapshots. The
+// [fcode] table of confFile is not used. Nicks are resolved through ids
+func (self *Module) Share(confFile string, ids *identity.Module) (*Module, error) {
+	mod, err := module.New(confFile)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	shared := newModule(mod, ids, self.fCodes)
+	shared.registerCommands()
+
+	return shared, nil
+}
+
+func newModule(mod *module.Module, ids *identity.Module, fCodes *fcManager) *Module {
+	self := &Module{
+		Module: mod,
+
+		ids:     ids,
+		fCodes:  fCodes,
+		members: newChannelMembers(ids),
 	}
+	self.pages = newPager(self)
 
-	registerCommands()
+	return self
 }
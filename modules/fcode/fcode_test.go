@@ -0,0 +1,113 @@
+package fcode
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/crimsonvoid/ayuko/harness"
+	"github.com/crimsonvoid/ayuko/modules/store"
+)
+
+func startFcode(t *testing.T) *harness.Bot {
+	mod, err := New("", t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return harness.StartModule(t, mod.Module)
+}
+
+func TestFcodeAddGet(t *testing.T) {
+	bot := startFcode(t)
+
+	msgs, err := bot.Say("alice", harness.Channel, ".fcode add 3ds 123456789012", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Target != "alice" || !strings.Contains(msgs[0].Text, "1234-5678-9012") {
+		t.Fatalf("add: got %v; want the normalized code confirmed to alice", msgs)
+	}
+
+	msgs, err = bot.Say("bob", harness.Channel, "@fcode alice", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Target != harness.Channel || !strings.Contains(msgs[0].Text, "1234-5678-9012") {
+		t.Fatalf("@fcode: got %v; want alice's code in %v", msgs, harness.Channel)
+	}
+
+	msgs, err = bot.Say("bob", harness.Channel, ".fcode alice", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Target != "bob" || msgs[0].Cmd != "NOTICE" {
+		t.Fatalf(".fcode: got %v; want a notice to bob", msgs)
+	}
+}
+
+func TestFcodeRemove(t *testing.T) {
+	bot := startFcode(t)
+
+	for _, text := range []string{".fcode add psn alice_psn", ".fcode rem psn"} {
+		if _, err := bot.Say("alice", harness.Channel, text, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msgs, err := bot.Say("bob", harness.Channel, ".fcode alice", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || strings.Contains(msgs[0].Text, "alice_psn") {
+		t.Errorf("after rem: got %v; want alice's code gone", msgs)
+	}
+}
+
+func TestFcodeBadCode(t *testing.T) {
+	bot := startFcode(t)
+
+	msgs, err := bot.Say("alice", harness.Channel, ".fcode add 3ds nope", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || !strings.HasPrefix(msgs[0].Text, "There was a problem adding you") {
+		t.Errorf("got %v; want the code rejected", msgs)
+	}
+}
+
+func TestChannelMembers(t *testing.T) {
+	members := newChannelMembers(nil)
+
+	members.Join("#a", "Alice")
+	members.Join("#b", "alice")
+
+	members.Part("#a", "alice")
+	if members.Has("#a", "alice") || !members.Has("#b", "alice") {
+		t.Error("Part() should only remove alice from #a")
+	}
+
+	members.Leave("#B")
+	if members.Has("#b", "alice") {
+		t.Error("alice is still a member of #b after the bot left it")
+	}
+
+	members.Join("#c", "alice")
+	members.Clear()
+	if members.Has("#c", "alice") {
+		t.Error("alice is still a member of #c after Clear()")
+	}
+}
+
+func TestConsoleBeforeConnect(t *testing.T) {
+	mod, err := New("", t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mod.fCodes.Save("codes.gob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mod.fCodes.Load("codes.gob"); err != store.ErrClosed {
+		t.Errorf("Load() before connecting = %v; want %v", err, store.ErrClosed)
+	}
+}
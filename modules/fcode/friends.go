@@ -5,13 +5,19 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"regexp"
 	"strconv"
+	"strings"
 	"sync"
-	"time"
+
+	"github.com/crimsonvoid/ayuko/modules/store"
 )
 
-type friendCode struct {
+// friendCode maps a System.Key to a user's code for it
+type friendCode map[string]string
+
+// legacyFriendCode is the fixed struct friend codes were stored as before
+// systems came from the registry
+type legacyFriendCode struct {
 	Nid       string
 	Wii, Wiiu string
 	Ds, Ds3   string
@@ -20,43 +26,216 @@ type friendCode struct {
 	Bnet      string
 }
 
+// codesFile is the on-disk layout of snapshots. Legacy files are a bare
+// map[string]*legacyFriendCode and are upgraded by Load()
+type codesFile struct {
+	Version int
+	Codes   map[string]friendCode
+	Privacy map[string]userPrivacy
+}
+
+// fcManager holds the friend codes, and the systems they are saved for,
+// shared by every network's fcode module
 type fcManager struct {
-	friendCodes map[string]*friendCode
+	friendCodes map[string]friendCode
+	privacy     map[string]userPrivacy
 	mut         sync.RWMutex
+
+	dataDir   string
+	registry  *Registry    // Set by loadConfig()
+	steam     *steamClient // Set by loadConfig()
+	snapshots *store.Snapshots
+	log       func(format string, v ...interface{})
+
+	db       *store.Store
+	users    int // Networks connected; the store is open while above zero
+	usersMut sync.Mutex
 }
 
-func NewfcManager() *fcManager {
-	return &fcManager{
-		friendCodes: make(map[string]*friendCode),
+func NewfcManager(dataDir string, log func(format string, v ...interface{})) *fcManager {
+	self := &fcManager{
+		friendCodes: make(map[string]friendCode),
+		privacy:     make(map[string]userPrivacy),
+
+		dataDir: dataDir,
+		log:     log,
 	}
+
+	self.snapshots = &store.Snapshots{
+		Dir:  dataDir,
+		Save: self.Save,
+		Load: self.Load,
+		Log:  log,
+
+		Interval: defaultAutosave,
+		Debounce: defaultDebounce,
+		Keep:     store.Retention{Hourly: 24, Daily: 7, Monthly: 12},
+	}
+
+	return self
 }
 
-func (self *fcManager) Start() error {
-	return self.Load("codes.gob")
+// Open() starts the store and snapshots when the first network connects
+func (self *fcManager) Open() error {
+	self.usersMut.Lock()
+	defer self.usersMut.Unlock()
+
+	if self.users++; self.users > 1 {
+		return nil
+	}
+
+	if err := self.Start(); err != nil {
+		self.users--
+
+		return err
+	}
+
+	if err := self.snapshots.Start(); err != nil {
+		self.Exit()
+		self.users--
+
+		return err
+	}
+
+	return nil
 }
 
-func (self *fcManager) Exit() error {
-	timeStamp := time.Now().UTC()
+// Close() writes a final snapshot and closes the store when the last network
+// disconnects
+func (self *fcManager) Close() error {
+	self.usersMut.Lock()
+	defer self.usersMut.Unlock()
+
+	if self.users == 0 {
+		return nil
+	}
+	if self.users--; self.users > 0 {
+		return nil
+	}
+
+	snapErr := self.snapshots.Exit()
+	if snapErr != nil {
+		self.log("Error saving codes snapshot: %v\n", snapErr)
+	}
+
+	if err := self.Exit(); err != nil {
+		return err
+	}
+
+	return snapErr
+}
+
+// Start() opens the store and loads every code, migrating codes stored by
+// earlier versions the first time
+func (self *fcManager) Start() error {
+	db, err := store.Open(self.dataDir + dbName)
+	if err != nil {
+		return err
+	}
+
+	self.mut.Lock()
+	self.db = db
+	self.mut.Unlock()
 
-	timedPath := fmt.Sprintf("%v-%02[2]d %[2]v", timeStamp.Year(), timeStamp.Month())
-	if err := os.MkdirAll(dataDir+timedPath, 755); err != nil {
+	if err := self.migrate(); err != nil {
 		return err
 	}
-	timedFileName := fmt.Sprintf("%v/%02v_(%02v.%02v).gob",
-		timedPath, timeStamp.Day(), timeStamp.Hour(), timeStamp.Minute())
 
-	if err := self.Save("codes.gob"); err != nil {
+	friendCodes := make(map[string]friendCode)
+
+	err = db.ForEach(codesBucket, func(nick string, dec store.Decoder) error {
+		fCode := make(friendCode)
+		if err := dec(&fCode); err != nil {
+			return err
+		}
+		friendCodes[nick] = fCode
+
+		return nil
+	})
+	if err != nil {
 		return err
 	}
-	if err := self.Save(timedFileName); err != nil {
+
+	privacy := make(map[string]userPrivacy)
+
+	err = db.ForEach(privacyBucket, func(nick string, dec store.Decoder) error {
+		prefs := make(userPrivacy)
+		if err := dec(&prefs); err != nil {
+			return err
+		}
+		privacy[nick] = prefs
+
+		return nil
+	})
+	if err != nil {
 		return err
 	}
 
+	self.mut.Lock()
+	self.friendCodes = friendCodes
+	self.privacy = privacy
+	self.mut.Unlock()
+
 	return nil
 }
 
+// migrate() imports legacy struct codes from the store or codes.gob
+func (self *fcManager) migrate() error {
+	if n, err := self.db.Len(codesBucket); err != nil || n > 0 {
+		return err
+	}
+
+	if n, err := self.db.Len(legacyBucket); err != nil {
+		return err
+	} else if n > 0 {
+		stored := make(map[string]interface{}, n)
+
+		err := self.db.ForEach(legacyBucket, func(nick string, dec store.Decoder) error {
+			legacy := legacyFriendCode{}
+			if err := dec(&legacy); err != nil {
+				return err
+			}
+			stored[nick] = legacy.upgrade()
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := self.db.Replace(codesBucket, stored); err != nil {
+			return err
+		}
+
+		return self.db.Drop(legacyBucket)
+	}
+
+	if _, err := os.Stat(self.dataDir + "codes.gob"); err == nil {
+		if err := self.Load("codes.gob"); err != nil {
+			return err
+		}
+
+		return os.Rename(self.dataDir+"codes.gob", self.dataDir+"codes.gob.imported")
+	}
+
+	return nil
+}
+
+// Exit() closes the store. Console commands fail with store.ErrClosed until
+// it is opened again
+func (self *fcManager) Exit() error {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	err := self.db.Close()
+	self.db = nil
+
+	return err
+}
+
+// Load() replaces every code with the snapshot in fileName
 func (self *fcManager) Load(fileName string) error {
-	file, err := os.Open(dataDir + fileName)
+	file, err := os.Open(self.dataDir + fileName)
 	if os.IsNotExist(err) {
 		return nil
 	} else if err != nil {
@@ -64,30 +243,94 @@ func (self *fcManager) Load(fileName string) error {
 	}
 	defer file.Close()
 
+	codes := codesFile{}
+
 	codesDec := gob.NewDecoder(file)
+	if err := codesDec.Decode(&codes); err != nil {
+		// Legacy; a bare map of structs
+		if _, err := file.Seek(0, 0); err != nil {
+			return err
+		}
+
+		legacy := make(map[string]*legacyFriendCode)
+		codesDec = gob.NewDecoder(file)
+		if err := codesDec.Decode(&legacy); err != nil {
+			return err
+		}
+
+		codes.Codes = make(map[string]friendCode, len(legacy))
+		for nick, fCode := range legacy {
+			codes.Codes[nick] = fCode.upgrade()
+		}
+	}
+
+	if codes.Codes == nil {
+		codes.Codes = make(map[string]friendCode)
+	}
+	if codes.Privacy == nil {
+		codes.Privacy = make(map[string]userPrivacy)
+	}
 
 	self.mut.Lock()
 	defer self.mut.Unlock()
 
-	return codesDec.Decode(&self.friendCodes)
-}
+	if self.db == nil {
+		return store.ErrClosed
+	}
 
-func (self *fcManager) Save(fileName string) error {
-	file, err := os.Create(dataDir + fileName)
-	if err != nil {
+	stored := make(map[string]interface{}, len(codes.Codes))
+	for nick, fCode := range codes.Codes {
+		stored[nick] = fCode
+	}
+	if err := self.db.Replace(codesBucket, stored); err != nil {
 		return err
 	}
-	defer file.Close()
+	self.friendCodes = codes.Codes
 
+	stored = make(map[string]interface{}, len(codes.Privacy))
+	for nick, prefs := range codes.Privacy {
+		stored[nick] = prefs
+	}
+	self.privacy = codes.Privacy
+
+	return self.db.Replace(privacyBucket, stored)
+}
+
+// Save() writes a snapshot of every code to fileName
+func (self *fcManager) Save(fileName string) error {
 	self.mut.RLock()
 	defer self.mut.RUnlock()
 
-	codesEnc := gob.NewEncoder(file)
+	return store.WriteGob(self.dataDir+fileName, codesFile{
+		Version: codesVersion,
+		Codes:   self.friendCodes,
+		Privacy: self.privacy,
+	})
+}
+
+// persist() does not lock. The callee should hold a lock
+func (self *fcManager) persist(nick string) {
+	var err error
+
+	if fCode, ok := self.friendCodes[nick]; ok {
+		err = self.db.Put(codesBucket, nick, fCode)
+	} else {
+		err = self.db.Delete(codesBucket, nick)
+	}
+
+	if err != nil {
+		self.log("Error storing codes for %v: %v\n", nick, err)
+	}
 
-	return codesEnc.Encode(self.friendCodes)
+	self.snapshots.Changed()
 }
 
 func (self *fcManager) String() string {
+	return self.format(nil)
+}
+
+// format() lists the codes v may see. A nil v sees everything
+func (self *fcManager) format(v *viewer) string {
 	self.mut.RLock()
 	defer self.mut.RUnlock()
 
@@ -103,45 +346,29 @@ func (self *fcManager) String() string {
 	outFmt := "%" + strconv.Itoa(maxNickLen+2) + "v: %v\n"
 
 	for nick, fCode := range self.friendCodes {
-		if len(nick) > maxNickLen {
-			maxNickLen = len(nick)
+		shown := make(friendCode, len(fCode))
+		for key, code := range fCode {
+			if self.visible(nick, key, v) {
+				shown[key] = code
+			}
 		}
 
-		out += fmt.Sprintf(outFmt, nick, fCode.String())
+		if len(shown) > 0 {
+			out += fmt.Sprintf(outFmt, nick, shown.format(self.registry))
+		}
 	}
 
 	return out
 }
 
-func (self *friendCode) String() string {
+// format() lists the codes in registry's display order
+func (self friendCode) format(registry *Registry) string {
 	out := ""
 
-	if self.Nid != "" {
-		out += fmt.Sprintf("(Nid: %v) ", self.Nid)
-	}
-	if self.Wii != "" {
-		out += fmt.Sprintf("(Wii: %v) ", self.Wii)
-	}
-	if self.Wiiu != "" {
-		out += fmt.Sprintf("(WiiU: %v) ", self.Wiiu)
-	}
-	if self.Ds != "" {
-		out += fmt.Sprintf("(DS: %v) ", self.Ds)
-	}
-	if self.Ds3 != "" {
-		out += fmt.Sprintf("(3DS: %v) ", self.Ds3)
-	}
-	if self.Live != "" {
-		out += fmt.Sprintf("(Live: %v) ", self.Live)
-	}
-	if self.Psn != "" {
-		out += fmt.Sprintf("(PSN: %v) ", self.Psn)
-	}
-	if self.Steam != "" {
-		out += fmt.Sprintf("(Steam: %v) ", self.Steam)
-	}
-	if self.Bnet != "" {
-		out += fmt.Sprintf("(Bnet: %v) ", self.Bnet)
+	for _, sys := range registry.Systems() {
+		if code := self[sys.Key]; code != "" {
+			out += fmt.Sprintf("(%v: %v) ", sys.Name, code)
+		}
 	}
 
 	return out
@@ -154,69 +381,52 @@ func (self *fcManager) Strings() map[string]string {
 	fcMap := make(map[string]string, len(self.friendCodes))
 
 	for nick, fCode := range self.friendCodes {
-		fcMap[nick] = fCode.String()
+		fcMap[nick] = fCode.format(self.registry)
 	}
 
 	return fcMap
 }
 
-func (self *fcManager) Add(nick, system, code string) bool {
+// Add() saves nick's code for system, a key or alias, returning the
+// normalized code
+func (self *fcManager) Add(nick, system, code string) (string, error) {
+	sys, ok := self.registry.Lookup(system)
+	if !ok {
+		return "", fmt.Errorf("Unknown system %v", system)
+	}
+
+	code, err := self.normalize(sys, code)
+	if err != nil {
+		return "", err
+	}
+
 	self.mut.Lock()
 	defer self.mut.Unlock()
 
-	var (
-		save *string
-		re   *regexp.Regexp
-	)
-
 	fCode, ok := self.friendCodes[nick]
 	if !ok {
-		fCode = new(friendCode)
-	}
-
-	switch system {
-	case "wii":
-		re = regexp.MustCompile(`\d{4}-\d{4}-\d{4}-\d{4}$`)
-		save = &fCode.Wii
-	case "wiiu":
-		re = regexp.MustCompile(`^.{6,16}\s?$`)
-		save = &fCode.Wiiu
-	case "nid":
-		re = regexp.MustCompile(`^.{6,16}\s?$`)
-		save = &fCode.Nid
-	case "ds":
-		re = regexp.MustCompile(`^\d{4}-\d{4}-\d{4}\s?$`)
-		save = &fCode.Ds
-	case "3ds":
-		re = regexp.MustCompile(`^\d{4}-\d{4}-\d{4}\s?$`)
-		save = &fCode.Ds3
-	case "psn":
-		re = regexp.MustCompile(`^.{6,16}\s?$`)
-		save = &fCode.Psn
-	case "live":
-		re = regexp.MustCompile(`^.{6,15}\s?$`)
-		save = &fCode.Live
-	case "steam":
-		// TODO|fcode - steam nick restrictions
-		re = regexp.MustCompile(`^.*`)
-		save = &fCode.Steam
-	case "bnet":
-		re = regexp.MustCompile(`^.*#\d{3,4}\s?$`)
-		save = &fCode.Bnet
-	default:
-		return false
-	}
-
-	if re.FindStringSubmatch(code) == nil {
-		return false
-	}
-
-	*save = code
+		fCode = make(friendCode)
+	}
+
+	fCode[sys.Key] = code
 	self.friendCodes[nick] = fCode
+	self.persist(nick)
+
+	return code, nil
+}
 
-	return true
+// normalize() validates code for sys, resolving Steam vanity names to
+// SteamID64s. It may call the Steam Web API, so callers should not hold a lock
+func (self *fcManager) normalize(sys *System, code string) (string, error) {
+	code, err := sys.Validate(code)
+	if err != nil || sys.Key != steamKey {
+		return code, err
+	}
+
+	return self.steam.Resolve(code)
 }
 
+// Remove() removes nick's code for system, or all of nick's codes for "*"
 func (self *fcManager) Remove(nick, system string) error {
 	self.mut.Lock()
 	defer self.mut.Unlock()
@@ -226,37 +436,29 @@ func (self *fcManager) Remove(nick, system string) error {
 		return errors.New("Nick not in database")
 	}
 
-	switch system {
-	case "wii":
-		fCode.Wii = ""
-	case "wiiu":
-		fCode.Wiiu = ""
-	case "nid":
-		fCode.Nid = ""
-	case "ds":
-		fCode.Ds = ""
-	case "3ds":
-		fCode.Ds3 = ""
-	case "psn":
-		fCode.Psn = ""
-	case "live":
-		fCode.Live = ""
-	case "steam":
-		fCode.Steam = ""
-	case "bnet":
-		fCode.Bnet = ""
-	case "*":
+	if system == "*" {
 		delete(self.friendCodes, nick)
+		self.persist(nick)
+
 		return nil
-	default:
+	}
+
+	sys, ok := self.registry.Lookup(system)
+	if !ok {
 		return errors.New("Unknown system")
 	}
-	self.friendCodes[nick] = fCode
+
+	delete(fCode, sys.Key)
+	if len(fCode) == 0 {
+		delete(self.friendCodes, nick)
+	}
+	self.persist(nick)
 
 	return nil
 }
 
-func (self *fcManager) GetUser(nick string) (map[string]string, error) {
+// GetUser() returns nick's codes v may see, keyed by System.Name
+func (self *fcManager) GetUser(nick string, v *viewer) (map[string]string, error) {
 	self.mut.RLock()
 	defer self.mut.RUnlock()
 
@@ -265,78 +467,60 @@ func (self *fcManager) GetUser(nick string) (map[string]string, error) {
 		return nil, errors.New("Nick not in database")
 	}
 
-	codes := make(map[string]string, 8)
-	if c := fCode.Wii; c != "" {
-		codes["Wii"] = c
-	}
-	if c := fCode.Wiiu; c != "" {
-		codes["WiiU"] = c
-	}
-	if c := fCode.Nid; c != "" {
-		codes["NID"] = c
-	}
-	if c := fCode.Ds; c != "" {
-		codes["DS"] = c
-	}
-	if c := fCode.Ds3; c != "" {
-		codes["3DS"] = c
-	}
-	if c := fCode.Live; c != "" {
-		codes["Live"] = c
-	}
-	if c := fCode.Psn; c != "" {
-		codes["PSN"] = c
-	}
-	if c := fCode.Steam; c != "" {
-		codes["Steam"] = c
-	}
-	if c := fCode.Bnet; c != "" {
-		codes["Bnet"] = c
+	codes := make(map[string]string, len(fCode))
+	for _, sys := range self.registry.Systems() {
+		if code := fCode[sys.Key]; code != "" && self.visible(nick, sys.Key, v) {
+			codes[sys.Name] = code
+		}
 	}
 
 	return codes, nil
 }
 
-func (self *fcManager) GetSystem(system string) map[string]string {
+// GetSystem() returns every nick's code for system, a key or alias, that v
+// may see
+func (self *fcManager) GetSystem(system string, v *viewer) map[string]string {
 	self.mut.RLock()
 	defer self.mut.RUnlock()
 
 	codeList := make(map[string]string, 8)
 
+	sys, ok := self.registry.Lookup(system)
+	if !ok {
+		return codeList
+	}
+
 	for nick, fCode := range self.friendCodes {
-		code := ""
-
-		switch system {
-		case "wii":
-			code = fCode.Wii
-		case "wiiu":
-			code = fCode.Wiiu
-		case "nid":
-			code = fCode.Nid
-		case "ds":
-			code = fCode.Ds
-		case "3ds":
-			code = fCode.Ds3
-		case "psn":
-			code = fCode.Psn
-		case "live":
-			code = fCode.Live
-		case "steam":
-			code = fCode.Steam
-		case "bnet":
-			code = fCode.Bnet
+		if code := fCode[sys.Key]; code != "" && self.visible(nick, sys.Key, v) {
+			codeList[nick] = code
 		}
+	}
+
+	return codeList
+}
+
+// upgrade() converts a legacy struct to a friendCode
+func (self *legacyFriendCode) upgrade() friendCode {
+	fCode := make(friendCode)
 
+	for key, code := range map[string]string{
+		"nid": self.Nid, "wii": self.Wii, "wiiu": self.Wiiu,
+		"ds": self.Ds, "3ds": self.Ds3,
+		"live": self.Live, "psn": self.Psn,
+		"steam": self.Steam, "bnet": self.Bnet,
+	} {
 		if code != "" {
-			codeList[nick] = code
+			fCode[key] = code
 		}
 	}
 
-	return codeList
+	return fCode
 }
 
-func FcHelp() string {
+func (self *Module) FcHelp() string {
 	return fmt.Sprintf("Save and retrieve gaming identities. "+
-		"Syntax: [%v%v]fcode [add|rem|list] [%v] (code) || .fcode nick",
-		PUBLIC, PRIV, systemsL)
+		"Syntax: [%v%v]fcode [add|rem|list] [%v] (code) || .fcode list system page n || "+
+		".fcode continue || .fcode nick || .fcode search text || "+
+		".fcode privacy [system|*] [public|channel|hidden|allow nicks...]",
+		PUBLIC, PRIV, strings.Join(self.fCodes.registry.Keys(), "|"))
 }
@@ -0,0 +1,131 @@
+package fcode
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// page is a run of output lines and where to send them
+type page struct {
+	Target string
+	Notice bool
+	Lines  []string
+}
+
+// continuation is the rest of a paged reply, resumed with `.fcode continue`
+type continuation struct {
+	page
+	Page, Pages int
+	Expires     time.Time
+}
+
+// pager holds each requester's unsent pages
+type pager struct {
+	mod     *Module
+	pending map[string]*continuation // map[resolved nick]
+	mut     sync.Mutex
+}
+
+func newPager(mod *Module) *pager {
+	return &pager{
+		mod:     mod,
+		pending: make(map[string]*continuation),
+	}
+}
+
+// splitLines() joins entries with sep into lines of at most max bytes. An
+// entry is never split across lines
+func splitLines(entries []string, sep string, max int) []string {
+	lines := make([]string, 0, 1)
+	line := ""
+
+	for _, entry := range entries {
+		switch {
+		case line == "":
+			line = entry
+		case len(line)+len(sep)+len(entry) > max:
+			lines = append(lines, line)
+			line = entry
+		default:
+			line += sep + entry
+		}
+	}
+
+	if line != "" {
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// Send() sends page number n (from 1) of out to its target and keeps the
+// pages after it for nick, who asked for them, to continue
+func (self *pager) Send(nick string, out page, n int) error {
+	pages := (len(out.Lines) + pageLines - 1) / pageLines
+	if n < 1 || n > pages {
+		return fmt.Errorf("there are only %v pages", pages)
+	}
+
+	cont := &continuation{
+		page:    out,
+		Page:    n,
+		Pages:   pages,
+		Expires: time.Now().Add(continueTTL),
+	}
+
+	self.mut.Lock()
+	delete(self.pending, self.mod.ids.Resolve(nick))
+	self.mut.Unlock()
+
+	self.send(nick, cont)
+
+	return nil
+}
+
+// Continue() sends nick's next page
+func (self *pager) Continue(nick string) bool {
+	key := self.mod.ids.Resolve(nick)
+
+	self.mut.Lock()
+	cont, ok := self.pending[key]
+	delete(self.pending, key)
+	self.mut.Unlock()
+
+	if !ok || time.Now().After(cont.Expires) {
+		return false
+	}
+
+	cont.Page++
+	cont.Expires = time.Now().Add(continueTTL)
+	self.send(nick, cont)
+
+	return true
+}
+
+func (self *pager) send(nick string, cont *continuation) {
+	start := (cont.Page - 1) * pageLines
+	end := start + pageLines
+	if end > len(cont.Lines) {
+		end = len(cont.Lines)
+	}
+
+	for _, line := range cont.Lines[start:end] {
+		if cont.Notice {
+			self.mod.Conn.Notice(cont.Target, line)
+		} else {
+			self.mod.Conn.Privmsg(cont.Target, line)
+		}
+	}
+
+	if cont.Page >= cont.Pages {
+		return
+	}
+
+	self.mod.Conn.Notice(nick, fmt.Sprintf("Page %v/%v. Say %vfcode continue for more",
+		cont.Page, cont.Pages, PRIV))
+
+	self.mut.Lock()
+	self.pending[self.mod.ids.Resolve(nick)] = cont
+	self.mut.Unlock()
+}
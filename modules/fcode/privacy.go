@@ -0,0 +1,262 @@
+package fcode
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/crimsonvoid/ayuko/modules/identity"
+)
+
+// Visibility levels for a user's codes
+const (
+	visPublic  = "public"  // Anyone
+	visChannel = "channel" // Only in channels the owner has been seen in
+	visAllow   = "allow"   // Only nicks on the owner's allowlist, privately
+	visHidden  = "hidden"  // Only the owner
+
+	allSystems = "*" // Privacy key for systems without their own setting
+)
+
+// privacy is the visibility of one of a user's systems
+type privacy struct {
+	Level string
+	Allow []string // Resolved nicks, for visAllow
+}
+
+// userPrivacy maps a System.Key, or allSystems, to its privacy
+type userPrivacy map[string]privacy
+
+func (self privacy) String() string {
+	if self.Level == visAllow {
+		return fmt.Sprintf("%v (%v)", self.Level, strings.Join(self.Allow, ", "))
+	}
+
+	return self.Level
+}
+
+// viewer is who a lookup's reply is shown to. Nick is empty when the reply
+// goes to a whole channel, and both are empty for redacted console output
+type viewer struct {
+	Nick    string // Resolved nick
+	Channel string
+
+	members *channelMembers // Of the network Channel is on
+}
+
+// lookup() returns the privacy for system, falling back to allSystems and
+// then visPublic
+func (self userPrivacy) lookup(system string) privacy {
+	if p, ok := self[system]; ok {
+		return p
+	}
+	if p, ok := self[allSystems]; ok {
+		return p
+	}
+
+	return privacy{Level: visPublic}
+}
+
+// visible() reports whether owner's code for system may be shown to v. A nil
+// v is the unrestricted console
+func (self *fcManager) visible(owner, system string, v *viewer) bool {
+	if v == nil || (v.Nick != "" && v.Nick == owner) {
+		return true
+	}
+
+	p := self.privacy[owner].lookup(system)
+
+	switch p.Level {
+	case visPublic:
+		return true
+	case visChannel:
+		return v.Channel != "" && v.members != nil && v.members.Has(v.Channel, owner)
+	case visAllow:
+		if v.Nick == "" {
+			return false
+		}
+		for _, nick := range p.Allow {
+			if nick == v.Nick {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// SetPrivacy() sets nick's privacy for system, a key, alias or allSystems.
+// allow are resolved nicks. Setting allSystems clears every per-system
+// setting
+func (self *fcManager) SetPrivacy(nick, system, level string, allow []string) error {
+	key := allSystems
+	if system != allSystems {
+		sys, ok := self.registry.Lookup(system)
+		if !ok {
+			return fmt.Errorf("Unknown system %v", system)
+		}
+		key = sys.Key
+	}
+
+	p := privacy{Level: strings.ToLower(level)}
+	switch p.Level {
+	case visPublic, visChannel, visHidden:
+	case visAllow:
+		if len(allow) == 0 {
+			return fmt.Errorf("Give the nicks to allow")
+		}
+		for _, a := range allow {
+			p.Allow = append(p.Allow, a)
+		}
+	default:
+		return fmt.Errorf("Unknown visibility %v", level)
+	}
+
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	prefs, ok := self.privacy[nick]
+	if !ok || key == allSystems {
+		prefs = make(userPrivacy)
+	}
+
+	if p.Level == visPublic && key == allSystems {
+		delete(self.privacy, nick)
+	} else {
+		prefs[key] = p
+		self.privacy[nick] = prefs
+	}
+	self.persistPrivacy(nick)
+
+	return nil
+}
+
+// Privacy() describes nick's privacy settings
+func (self *fcManager) Privacy(nick string) string {
+	self.mut.RLock()
+	defer self.mut.RUnlock()
+
+	prefs := self.privacy[nick]
+	settings := []string{"default: " + prefs.lookup(allSystems).String()}
+
+	keys := make([]string, 0, len(prefs))
+	for key := range prefs {
+		if key != allSystems {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		settings = append(settings, fmt.Sprintf("%v: %v", key, prefs[key]))
+	}
+
+	return strings.Join(settings, "; ")
+}
+
+// persistPrivacy() does not lock. The callee should hold a lock
+func (self *fcManager) persistPrivacy(nick string) {
+	var err error
+
+	if prefs, ok := self.privacy[nick]; ok {
+		err = self.db.Put(privacyBucket, nick, prefs)
+	} else {
+		err = self.db.Delete(privacyBucket, nick)
+	}
+
+	if err != nil {
+		self.log("Error storing privacy for %v: %v\n", nick, err)
+	}
+
+	self.snapshots.Changed()
+}
+
+// channelMembers tracks the (lowercased) nicks in each channel the bot is in.
+// It is cleared on connecting and disconnecting, and rebuilt from NAMES
+type channelMembers struct {
+	ids      *identity.Module
+	channels map[string]map[string]bool
+	mut      sync.RWMutex
+}
+
+func newChannelMembers(ids *identity.Module) *channelMembers {
+	return &channelMembers{
+		ids:      ids,
+		channels: make(map[string]map[string]bool),
+	}
+}
+
+// Has() reports whether anyone in channel resolves to the identity owner
+func (self *channelMembers) Has(channel, owner string) bool {
+	self.mut.RLock()
+	defer self.mut.RUnlock()
+
+	for nick := range self.channels[strings.ToLower(channel)] {
+		if self.ids.Resolve(nick) == owner {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (self *channelMembers) Join(channel, nick string) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	nick = strings.ToLower(nick)
+	channel = strings.ToLower(channel)
+	if self.channels[channel] == nil {
+		self.channels[channel] = make(map[string]bool)
+	}
+	self.channels[channel][nick] = true
+}
+
+func (self *channelMembers) Part(channel, nick string) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	nick = strings.ToLower(nick)
+	delete(self.channels[strings.ToLower(channel)], nick)
+}
+
+// Leave() forgets everyone in channel, for when the bot itself leaves it
+func (self *channelMembers) Leave(channel string) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	delete(self.channels, strings.ToLower(channel))
+}
+
+// Clear() forgets every channel
+func (self *channelMembers) Clear() {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	self.channels = make(map[string]map[string]bool)
+}
+
+// Quit() removes nick from every channel
+func (self *channelMembers) Quit(nick string) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	nick = strings.ToLower(nick)
+	for _, nicks := range self.channels {
+		delete(nicks, nick)
+	}
+}
+
+func (self *channelMembers) Rename(oldNick, newNick string) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	oldNick, newNick = strings.ToLower(oldNick), strings.ToLower(newNick)
+	for _, nicks := range self.channels {
+		if nicks[oldNick] {
+			delete(nicks, oldNick)
+			nicks[newNick] = true
+		}
+	}
+}
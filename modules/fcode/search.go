@@ -0,0 +1,196 @@
+package fcode
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/crimsonvoid/ayuko/modules/identity"
+)
+
+const (
+	maxSuggestions = 5
+	minPrefixLen   = 3 // Shortest query that suggests nicks it starts
+)
+
+// awaySuffixes are stripped from the end of a nick before it is looked up
+var awaySuffixes = []string{"_", "`", "^"}
+
+// trimNick() lowercases nick and strips away suffixes, eg "Nick|away" and
+// "nick__" -> "nick"
+func trimNick(nick string) string {
+	nick = strings.ToLower(nick)
+	if i := strings.Index(nick, "|"); i > 0 {
+		nick = nick[:i]
+	}
+
+	for trimmed := ""; trimmed != nick; {
+		trimmed = nick
+		for _, suffix := range awaySuffixes {
+			if len(nick) > len(suffix) {
+				nick = strings.TrimSuffix(nick, suffix)
+			}
+		}
+	}
+
+	return nick
+}
+
+// Find() returns the identity, through ids, codes are saved under for nick,
+// trying nick as given and then with away suffixes stripped
+func (self *fcManager) Find(ids *identity.Module, nick string) (string, bool) {
+	self.mut.RLock()
+	defer self.mut.RUnlock()
+
+	for _, name := range []string{nick, trimNick(nick)} {
+		owner := ids.Resolve(name)
+		if _, ok := self.friendCodes[owner]; ok {
+			return owner, true
+		}
+	}
+
+	return "", false
+}
+
+// Suggest() returns up to maxSuggestions saved identities close to nick, with
+// a code v may see, closest first
+func (self *fcManager) Suggest(ids *identity.Module, nick string, v *viewer) []string {
+	nick = trimNick(nick)
+	maxDist := 1 + len(nick)/4
+
+	matches := make(rankedNicks, 0, maxSuggestions)
+
+	self.forVisible(ids, v, func(owner string, names []string) {
+		best := -1
+		for _, name := range names {
+			dist := editDistance(nick, trimNick(name))
+			if len(nick) >= minPrefixLen && strings.HasPrefix(name, nick) {
+				dist = 0
+			}
+			if best < 0 || dist < best {
+				best = dist
+			}
+		}
+
+		if best <= maxDist {
+			matches = append(matches, rankedNick{owner, best})
+		}
+	})
+
+	sort.Sort(matches)
+	if len(matches) > maxSuggestions {
+		matches = matches[:maxSuggestions]
+	}
+
+	return matches.Nicks()
+}
+
+// Search() returns every saved identity with a code v may see whose name or
+// aliases contain query, sorted
+func (self *fcManager) Search(ids *identity.Module, query string, v *viewer) []string {
+	query = strings.ToLower(query)
+	found := make([]string, 0, 8)
+
+	self.forVisible(ids, v, func(owner string, names []string) {
+		for _, name := range names {
+			if strings.Contains(name, query) {
+				found = append(found, owner)
+
+				return
+			}
+		}
+	})
+
+	sort.Strings(found)
+
+	return found
+}
+
+// forVisible() calls fn with every identity that has a code v may see, and
+// the identity's names: itself and its aliases in ids
+func (self *fcManager) forVisible(ids *identity.Module, v *viewer, fn func(owner string, names []string)) {
+	self.mut.RLock()
+	defer self.mut.RUnlock()
+
+	for owner, fCode := range self.friendCodes {
+		shown := false
+		for key := range fCode {
+			if self.visible(owner, key, v) {
+				shown = true
+				break
+			}
+		}
+
+		if shown {
+			fn(owner, append([]string{owner}, ids.Aliases(owner)...))
+		}
+	}
+}
+
+// editDistance() is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+
+	return a
+}
+
+type rankedNick struct {
+	Nick string
+	Dist int
+}
+
+type rankedNicks []rankedNick
+
+func (self rankedNicks) Len() int {
+	return len(self)
+}
+
+func (self rankedNicks) Less(i, j int) bool {
+	if self[i].Dist != self[j].Dist {
+		return self[i].Dist < self[j].Dist
+	}
+
+	return self[i].Nick < self[j].Nick
+}
+
+func (self rankedNicks) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+
+func (self rankedNicks) Nicks() []string {
+	nicks := make([]string, len(self))
+	for i, ranked := range self {
+		nicks[i] = ranked.Nick
+	}
+
+	return nicks
+}
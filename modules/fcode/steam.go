@@ -0,0 +1,193 @@
+package fcode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	steamKey     = "steam" // System.Key of Steam
+	steamAPI     = "https://api.steampowered.com"
+	steamTimeout = time.Second * 10
+
+	steamProfileTTL = time.Hour       // How long Describe() reuses a profile
+	steamFailTTL    = time.Minute * 5 // How long Describe() skips a failed lookup
+)
+
+var (
+	// SteamID64s of individual accounts
+	steamIdRe = regexp.MustCompile(`^7656119\d{10}$`)
+	// steamcommunity.com/profiles/<SteamID64> and steamcommunity.com/id/<vanity>
+	steamProfileRe = regexp.MustCompile(
+		`(?i)^(?:https?://)?(?:www\.)?steamcommunity\.com/(?:profiles|id)/([^/?#\s]+)/?$`)
+)
+
+// steamClient talks to the Steam Web API. Without a key codes are saved as
+// given
+type steamClient struct {
+	Key string
+	Api string // Base URL, without a trailing slash
+
+	client *http.Client
+
+	described map[string]steamDescription // map[SteamID64]Describe() result
+	mut       sync.Mutex
+}
+
+type steamDescription struct {
+	text    string
+	expires time.Time
+}
+
+// steamProfile is a public Steam profile
+type steamProfile struct {
+	SteamId     string `json:"steamid"`
+	PersonaName string `json:"personaname"`
+	ProfileUrl  string `json:"profileurl"`
+}
+
+func newSteamClient(key, api string) *steamClient {
+	if api == "" {
+		api = steamAPI
+	}
+
+	return &steamClient{
+		Key: key,
+		Api: strings.TrimRight(api, "/"),
+
+		client:    &http.Client{Timeout: steamTimeout},
+		described: make(map[string]steamDescription),
+	}
+}
+
+// steamProfileId() returns the SteamID64 or vanity name in a profile link,
+// or code if it is not one
+func steamProfileId(code string) string {
+	if res := steamProfileRe.FindStringSubmatch(code); res != nil {
+		return res[1]
+	}
+
+	return code
+}
+
+// Resolve() returns the SteamID64 for code, a SteamID64 or vanity name
+func (self *steamClient) Resolve(code string) (string, error) {
+	if self.Key == "" || steamIdRe.MatchString(code) {
+		return code, nil
+	}
+
+	resp := struct {
+		Response struct {
+			SteamId string `json:"steamid"`
+			Success int    `json:"success"`
+		} `json:"response"`
+	}{}
+
+	err := self.get("/ISteamUser/ResolveVanityURL/v0001/", url.Values{
+		"vanityurl": {code},
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.Response.Success != 1 || !steamIdRe.MatchString(resp.Response.SteamId) {
+		return "", fmt.Errorf("Steam has no profile named %v", code)
+	}
+
+	return resp.Response.SteamId, nil
+}
+
+// Profile() looks up the profile of a SteamID64
+func (self *steamClient) Profile(steamId string) (*steamProfile, error) {
+	if self.Key == "" || !steamIdRe.MatchString(steamId) {
+		return nil, errors.New("Not a SteamID64, or no Steam API key")
+	}
+
+	resp := struct {
+		Response struct {
+			Players []steamProfile `json:"players"`
+		} `json:"response"`
+	}{}
+
+	err := self.get("/ISteamUser/GetPlayerSummaries/v0002/", url.Values{
+		"steamids": {steamId},
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range resp.Response.Players {
+		if resp.Response.Players[i].SteamId == steamId {
+			return &resp.Response.Players[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("Steam has no profile %v", steamId)
+}
+
+// Describe() returns the persona name and profile link for code, or code
+// itself if it is not a SteamID64 or its profile cannot be found. Results
+// are reused for steamProfileTTL, and failures for steamFailTTL
+func (self *steamClient) Describe(code string) (string, error) {
+	if self.Key == "" || !steamIdRe.MatchString(code) {
+		return code, nil
+	}
+
+	now := time.Now()
+
+	self.mut.Lock()
+	desc, ok := self.described[code]
+	self.mut.Unlock()
+
+	if ok && now.Before(desc.expires) {
+		return desc.text, nil
+	}
+
+	desc = steamDescription{code, now.Add(steamFailTTL)}
+
+	profile, err := self.Profile(code)
+	if err == nil {
+		desc = steamDescription{fmt.Sprintf("%v %v", profile.PersonaName, profile.ProfileUrl),
+			now.Add(steamProfileTTL)}
+	}
+
+	self.mut.Lock()
+	self.described[code] = desc
+	for id, d := range self.described {
+		if now.After(d.expires) {
+			delete(self.described, id)
+		}
+	}
+	self.mut.Unlock()
+
+	return desc.text, err
+}
+
+func (self *steamClient) get(method string, query url.Values, v interface{}) error {
+	query.Set("key", self.Key)
+	query.Set("format", "json")
+
+	resp, err := self.client.Get(self.Api + method + "?" + query.Encode())
+	if err != nil {
+		// Keep the key out of logs
+		if urlErr, ok := err.(*url.Error); ok {
+			urlErr.URL = self.Api + method
+		}
+
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Steam API response status %v", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
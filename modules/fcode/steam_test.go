@@ -0,0 +1,189 @@
+package fcode
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/crimsonvoid/ayuko/modules/store"
+)
+
+const (
+	testSteamKey = "sekrit"
+	testSteamId  = "76561197960287930"
+)
+
+// steamServer() stands in for the Steam Web API. Vanity "gabelogannewell"
+// resolves to testSteamId, which has a profile
+func steamServer(calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls != nil {
+			atomic.AddInt32(calls, 1)
+		}
+		if r.URL.Query().Get("key") != testSteamKey {
+			http.Error(w, "bad key", http.StatusForbidden)
+
+			return
+		}
+
+		switch r.URL.Path {
+		case "/ISteamUser/ResolveVanityURL/v0001/":
+			if r.URL.Query().Get("vanityurl") == "gabelogannewell" {
+				fmt.Fprintf(w, `{"response":{"steamid":"%v","success":1}}`, testSteamId)
+
+				return
+			}
+
+			fmt.Fprint(w, `{"response":{"success":42,"message":"No match"}}`)
+		case "/ISteamUser/GetPlayerSummaries/v0002/":
+			if r.URL.Query().Get("steamids") == testSteamId {
+				fmt.Fprintf(w, `{"response":{"players":[{"steamid":"%v","personaname":"Rabscuttle",`+
+					`"profileurl":"https://steamcommunity.com/id/gabelogannewell/"}]}}`, testSteamId)
+
+				return
+			}
+
+			fmt.Fprint(w, `{"response":{"players":[]}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestSteamResolveVanity(t *testing.T) {
+	srv := steamServer(nil)
+	defer srv.Close()
+
+	steam := newSteamClient(testSteamKey, srv.URL)
+
+	id, err := steam.Resolve("gabelogannewell")
+	if err != nil || id != testSteamId {
+		t.Errorf("Resolve(vanity) = %q, %v; want %q", id, err, testSteamId)
+	}
+}
+
+func TestSteamResolveSteamId(t *testing.T) {
+	var calls int32
+
+	srv := steamServer(&calls)
+	defer srv.Close()
+
+	steam := newSteamClient(testSteamKey, srv.URL)
+
+	id, err := steam.Resolve(testSteamId)
+	if err != nil || id != testSteamId {
+		t.Errorf("Resolve(SteamID64) = %q, %v; want %q", id, err, testSteamId)
+	}
+	if calls := atomic.LoadInt32(&calls); calls != 0 {
+		t.Errorf("Resolve(SteamID64) made %v API calls; want 0", calls)
+	}
+}
+
+func TestSteamProfileLink(t *testing.T) {
+	for link, want := range map[string]string{
+		"https://steamcommunity.com/profiles/" + testSteamId + "/": testSteamId,
+		"steamcommunity.com/id/gabelogannewell":                    "gabelogannewell",
+		"http://www.steamcommunity.com/id/gabelogannewell/":        "gabelogannewell",
+		"gabelogannewell":                        "gabelogannewell",
+		"https://example.com/id/gabelogannewell": "https://example.com/id/gabelogannewell",
+	} {
+		if got := steamProfileId(link); got != want {
+			t.Errorf("steamProfileId(%q) = %q; want %q", link, got, want)
+		}
+	}
+}
+
+func TestSteamNonOK(t *testing.T) {
+	srv := steamServer(nil)
+	defer srv.Close()
+
+	steam := newSteamClient("wrong", srv.URL)
+
+	if _, err := steam.Resolve("gabelogannewell"); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Resolve() with a rejected key = %v; want a 403 error", err)
+	}
+}
+
+func TestSteamNoMatch(t *testing.T) {
+	srv := steamServer(nil)
+	defer srv.Close()
+
+	steam := newSteamClient(testSteamKey, srv.URL)
+
+	if id, err := steam.Resolve("nobody"); err == nil {
+		t.Errorf("Resolve() with success != 1 = %q; want an error", id)
+	}
+}
+
+func TestSteamDescribeCached(t *testing.T) {
+	var calls int32
+
+	srv := steamServer(&calls)
+	defer srv.Close()
+
+	steam := newSteamClient(testSteamKey, srv.URL)
+
+	for i := 0; i < 3; i++ {
+		desc, err := steam.Describe(testSteamId)
+		if err != nil || !strings.HasPrefix(desc, "Rabscuttle ") {
+			t.Fatalf("Describe() = %q, %v; want the persona name and link", desc, err)
+		}
+	}
+	if calls := atomic.LoadInt32(&calls); calls != 1 {
+		t.Errorf("Describe() made %v API calls for one profile; want 1", calls)
+	}
+}
+
+func TestSteamErrorHidesKey(t *testing.T) {
+	srv := steamServer(nil)
+	srv.Close() // Refuse connections
+
+	steam := newSteamClient(testSteamKey, srv.URL)
+
+	_, err := steam.Resolve("gabelogannewell")
+	if err == nil {
+		t.Fatal("Resolve() against a closed server succeeded")
+	}
+	if strings.Contains(err.Error(), testSteamKey) {
+		t.Errorf("Resolve() error reveals the key: %v", err)
+	}
+}
+
+func TestSteamImportResolved(t *testing.T) {
+	srv := steamServer(nil)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	mod, err := New("", dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod.fCodes.steam = newSteamClient(testSteamKey, srv.URL)
+
+	export := `{"version": 1, "codes": {"alice": {"steam": "gabelogannewell"}, "bob": {"steam": "nobody"}}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "codes.json"), []byte(export), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mod.fCodes.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer mod.fCodes.Close()
+
+	report, err := mod.fCodes.Import(nil, "codes.json", store.Merge, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Added != 1 || len(report.Invalid) != 1 {
+		t.Errorf("Import() = %v; want alice added and bob rejected", report)
+	}
+
+	if codes := mod.fCodes.Strings(); !strings.Contains(codes["alice"], testSteamId) {
+		t.Errorf("alice's codes = %q; want the resolved SteamID64 %v", codes["alice"], testSteamId)
+	}
+}
@@ -0,0 +1,199 @@
+package fcode
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// System describes a gaming system codes can be saved for. Systems come from
+// defaultSystems and the [[fcode.systems]] tables of the module config
+type System struct {
+	Key       string   `toml:"key"`       // Stored key, eg "3ds"
+	Name      string   `toml:"name"`      // Display name, eg "3DS"
+	Aliases   []string `toml:"aliases"`   // Other names accepted in commands
+	Pattern   string   `toml:"pattern"`   // Codes must match after normalizing
+	Normalize []string `toml:"normalize"` // Normalizers applied in order
+
+	re *regexp.Regexp
+}
+
+// normalizers rewrite a code before it is validated and saved. Codes are
+// always trimmed first
+var normalizers = map[string]func(string) string{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"nospace": func(code string) string {
+		return strings.Join(strings.Fields(code), "")
+	},
+	// Digits in groups of four, eg "123456789012" -> "1234-5678-9012"
+	"groups4": func(code string) string {
+		return groups4(digitsOf(code))
+	},
+	// "sw 1234 5678 9012", "123456789012" -> "SW-1234-5678-9012"
+	"switch": func(code string) string {
+		if digits := digitsOf(code); len(digits) == 12 {
+			return "SW-" + groups4(digits)
+		}
+
+		return strings.ToUpper(code)
+	},
+	// Profile links -> their SteamID64 or vanity name
+	"steam": steamProfileId,
+	// "@Name" -> "name"; legacy "Name#1234" tags keep their case
+	"discord": func(code string) string {
+		code = strings.TrimPrefix(code, "@")
+		if !strings.Contains(code, "#") {
+			code = strings.ToLower(code)
+		}
+
+		return code
+	},
+}
+
+func digitsOf(code string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, code)
+}
+
+func groups4(digits string) string {
+	groups := make([]string, 0, len(digits)/4+1)
+	for len(digits) > 4 {
+		groups = append(groups, digits[:4])
+		digits = digits[4:]
+	}
+
+	return strings.Join(append(groups, digits), "-")
+}
+
+var defaultSystems = []System{
+	{Key: "switch", Name: "Switch", Aliases: []string{"ns", "nsw"},
+		Pattern: `^SW-\d{4}-\d{4}-\d{4}$`, Normalize: []string{"switch"}},
+	{Key: "xbox", Name: "Xbox", Aliases: []string{"gamertag", "xbl"},
+		Pattern: `^[A-Za-z][A-Za-z0-9 ]{0,14}(#\d{1,4})?$`},
+	{Key: "psn", Name: "PSN", Aliases: []string{"ps"}, Pattern: `^[A-Za-z][\w-]{2,15}$`},
+	// SteamID64s and vanity names. Vanity names are resolved to SteamID64s
+	// when there is a Steam API key
+	{Key: steamKey, Name: "Steam", Pattern: `^[\w-]{2,32}$`, Normalize: []string{"steam"}},
+	{Key: "epic", Name: "Epic", Aliases: []string{"egs", "epicgames"},
+		Pattern: `^[\w .-]{3,16}$`},
+	{Key: "riot", Name: "Riot ID", Aliases: []string{"lol", "valorant"},
+		Pattern: `^[^#]{3,16}#[A-Za-z0-9]{3,5}$`},
+	{Key: "bnet", Name: "Battle.net", Aliases: []string{"battlenet", "battletag"},
+		Pattern: `^[^\s#]{2,12}#\d{3,6}$`, Normalize: []string{"nospace"}},
+	{Key: "discord", Name: "Discord", Pattern: `^([a-z0-9_.]{2,32}|[^@#:]{2,32}#\d{4})$`,
+		Normalize: []string{"discord"}},
+	{Key: "gog", Name: "GOG", Pattern: `^[A-Za-z0-9_-]{3,20}$`},
+
+	// Legacy systems
+	{Key: "3ds", Name: "3DS", Pattern: `^\d{4}-\d{4}-\d{4}$`, Normalize: []string{"groups4"}},
+	{Key: "ds", Name: "DS", Pattern: `^\d{4}-\d{4}-\d{4}$`, Normalize: []string{"groups4"}},
+	{Key: "wii", Name: "Wii", Pattern: `^\d{4}-\d{4}-\d{4}-\d{4}$`, Normalize: []string{"groups4"}},
+	{Key: "wiiu", Name: "WiiU", Pattern: `^.{6,16}$`},
+	{Key: "nid", Name: "NID", Pattern: `^.{6,16}$`},
+	{Key: "live", Name: "Live", Pattern: `^.{6,15}$`},
+}
+
+// Validate() normalizes code and checks it against the system's pattern
+func (self *System) Validate(code string) (string, error) {
+	code = strings.TrimSpace(code)
+	for _, name := range self.Normalize {
+		code = normalizers[name](code)
+	}
+
+	if !self.re.MatchString(code) {
+		return "", fmt.Errorf("%q is not a valid %v code", code, self.Name)
+	}
+
+	return code, nil
+}
+
+type Registry struct {
+	systems []*System
+	byName  map[string]*System // Keys and aliases
+}
+
+// NewRegistry() compiles defs, in order. Definitions with the same key as an
+// earlier one replace it
+func NewRegistry(defs []System) (*Registry, error) {
+	registry := &Registry{
+		systems: make([]*System, 0, len(defs)),
+		byName:  make(map[string]*System),
+	}
+
+	for i := range defs {
+		sys := defs[i]
+		sys.Key = strings.ToLower(sys.Key)
+
+		if sys.Key == "" || strings.ContainsAny(sys.Key, " *") {
+			return nil, fmt.Errorf("Invalid system key %q", sys.Key)
+		}
+		if sys.Name == "" {
+			sys.Name = sys.Key
+		}
+		if sys.Pattern == "" {
+			sys.Pattern = `^.+$`
+		}
+		for _, name := range sys.Normalize {
+			if _, ok := normalizers[name]; !ok {
+				return nil, fmt.Errorf("Unknown normalizer %q for %v", name, sys.Key)
+			}
+		}
+
+		var err error
+		if sys.re, err = regexp.Compile(sys.Pattern); err != nil {
+			return nil, fmt.Errorf("Invalid pattern for %v: %v", sys.Key, err)
+		}
+
+		replaced := false
+		for j, old := range registry.systems {
+			if old.Key == sys.Key {
+				registry.systems[j] = &sys
+				replaced = true
+			}
+		}
+		if !replaced {
+			registry.systems = append(registry.systems, &sys)
+		}
+	}
+
+	for _, sys := range registry.systems {
+		for _, name := range append([]string{sys.Key}, sys.Aliases...) {
+			name = strings.ToLower(name)
+
+			if other, ok := registry.byName[name]; ok && other != sys {
+				return nil, fmt.Errorf("%q names both %v and %v", name, other.Key, sys.Key)
+			}
+			registry.byName[name] = sys
+		}
+	}
+
+	return registry, nil
+}
+
+// Lookup() finds a system by key or alias
+func (self *Registry) Lookup(name string) (*System, bool) {
+	sys, ok := self.byName[strings.ToLower(name)]
+
+	return sys, ok
+}
+
+// Systems() returns every system in display order
+func (self *Registry) Systems() []*System {
+	return self.systems
+}
+
+// Keys() returns the key of every system in display order
+func (self *Registry) Keys() []string {
+	keys := make([]string, 0, len(self.systems))
+	for _, sys := range self.systems {
+		keys = append(keys, sys.Key)
+	}
+
+	return keys
+}
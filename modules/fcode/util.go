@@ -3,6 +3,7 @@ package fcode
 import (
 	"fmt"
 	"regexp"
+	"strings"
 )
 
 func matchGroups(reg *regexp.Regexp, s string) (map[string]string, error) {
@@ -21,3 +22,8 @@ func matchGroups(reg *regexp.Regexp, s string) (map[string]string, error) {
 
 	return groups, nil
 }
+
+// isMe() reports whether nick is the bot's own
+func (self *Module) isMe(nick string) bool {
+	return self.Conn != nil && strings.EqualFold(nick, self.Conn.Me().Nick)
+}
@@ -3,7 +3,10 @@ package fcode
 import (
 	"fmt"
 	"regexp"
+	"time"
 
+	"github.com/crimsonvoid/ayuko/modules/identity"
+	"github.com/crimsonvoid/ayuko/modules/raw"
 	"github.com/crimsonvoid/irclib/module"
 )
 
@@ -14,25 +17,51 @@ const (
 	nickR = `(?P<nick>[\w{}\[\]^|` + "`" + `-]+)`
 	modeR = `(?P<mode>[` + PRIV + PUBLIC + `])`
 
-	systemsL = `wii|wiiu|nid|ds|3ds|psn|live|steam|bnet`
-	systemsR = `(?P<system>` + systemsL + `)`
+	systemR = `(?P<system>\S+)` // A System.Key or alias
 )
 
 var (
-	fcAdd = regexp.MustCompile(fmt.Sprintf(`(?i)^%vfcode add %v (?P<fcode>.*)`, modeR, systemsR))
-	fcRem = regexp.MustCompile(fmt.Sprintf(`(?i)^%vfcode rem %v$`,
-		modeR, `(?P<system>`+systemsL+`|\*)`))
+	fcAdd  = regexp.MustCompile(fmt.Sprintf(`(?i)^%vfcode add %v (?P<fcode>.*)`, modeR, systemR))
+	fcRem  = regexp.MustCompile(fmt.Sprintf(`(?i)^%vfcode rem %v$`, modeR, systemR))
 	fcGet  = regexp.MustCompile(fmt.Sprintf(`(?i)^%vfcode %v\s?$`, modeR, nickR))
-	fcList = regexp.MustCompile(fmt.Sprintf(`(?i)^%vfcode list %v$`, modeR, systemsR))
+	fcList = regexp.MustCompile(fmt.Sprintf(`(?i)^%vfcode list %v( page (?P<page>\d+))?$`,
+		modeR, systemR))
 	fcHelp = regexp.MustCompile(fmt.Sprintf(`(?i)^%vfcodehelp$`, modeR))
 
-	fCodes = NewfcManager()
+	fcPrivacy = regexp.MustCompile(fmt.Sprintf(
+		`(?i)^%vfcode privacy( (?P<system>\S+) (?P<level>public|channel|hidden|allow)( (?P<nicks>.+))?)?\s*$`,
+		modeR))
+
+	fcContinue = regexp.MustCompile(fmt.Sprintf(`(?i)^%vfcode (continue|more)\s*$`, modeR))
+	fcSearch   = regexp.MustCompile(fmt.Sprintf(`(?i)^%vfcode search (?P<query>\S+)\s*$`, modeR))
 )
 
 const (
-	dataDir = "./data/fcode/"
-)
+	defaultDataDir = "./data/fcode/"
+	dbName         = "codes.db"
 
-var (
-	Module *module.Module
+	codesBucket   = "friendcodes"
+	legacyBucket  = "codes" // legacyFriendCode structs
+	privacyBucket = "privacy"
+	codesVersion  = 2 // codesFile layout version
+
+	maxLineLen  = 400              // Bytes of output per line, leaving room for the prefix
+	pageLines   = 3                // Lines sent per page
+	continueTTL = time.Minute * 10 // How long `.fcode continue` works after a page
+
+	defaultAutosave = time.Hour   // Snapshot interval
+	defaultDebounce = time.Minute // Snapshot delay after a change
+
+	namesPrefixes = "~&@%+" // Channel modes prefixed to nicks in NAMES
 )
+
+// Module is one instance of the fcode module
+type Module struct {
+	*module.Module
+
+	ids     *identity.Module // Resolves nicks; nil treats every nick as its own identity
+	fCodes  *fcManager       // Shared with modules made by Share()
+	members *channelMembers
+	events  raw.Handlers // Channel membership
+	pages   *pager
+}
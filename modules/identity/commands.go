@@ -0,0 +1,135 @@
+package identity
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/crimsonvoid/irclib/module"
+	irc "github.com/fluffle/goirc/client"
+)
+
+func (self *Module) registerCommands() {
+	var capOnce sync.Once
+
+	self.Preconnect = func() error {
+		capOnce = sync.Once{}
+		self.events.Attach(self.Conn)
+
+		return self.identities.Start()
+	}
+	self.Disconnect = func() error {
+		self.events.Detach()
+
+		return self.identities.Exit()
+	}
+
+	self.regEvNick()
+	self.regEvJoin(&capOnce)
+	self.regEvAccount()
+	self.regComAlias()
+}
+
+func (self *Module) regEvNick() {
+	self.events.Handle("NICK", func(line *irc.Line) {
+		if len(line.Args) == 0 {
+			return
+		}
+
+		self.identities.NickChange(line.Nick, line.Args[0])
+	})
+
+	self.events.Handle("QUIT", func(line *irc.Line) {
+		self.identities.Quit(line.Nick)
+	})
+}
+
+// regEvJoin() requests account-notify and extended-join after the first JOIN
+// and records accounts sent with extended-join
+func (self *Module) regEvJoin(capOnce *sync.Once) {
+	self.events.Handle("JOIN", func(line *irc.Line) {
+		if !self.useAccounts {
+			return
+		}
+
+		capOnce.Do(func() {
+			self.Conn.Raw("CAP REQ :account-notify extended-join")
+		})
+
+		// extended-join: JOIN #channel account :realname
+		if len(line.Args) >= 3 {
+			self.identities.SetAccount(line.Nick, line.Args[1])
+		}
+	})
+}
+
+func (self *Module) regEvAccount() {
+	self.events.Handle("ACCOUNT", func(line *irc.Line) {
+		if !self.useAccounts || len(line.Args) == 0 {
+			return
+		}
+
+		self.identities.SetAccount(line.Nick, line.Args[0])
+	})
+}
+
+func (self *Module) regComAlias() {
+	self.Register(module.E_PRIVMSG, aliasR, func(line *irc.Line) {
+		groups, _ := matchGroups(aliasR, line.Text())
+		nick := groups["nick"]
+
+		switch strings.ToLower(groups["action"]) {
+		case "add":
+			ok, err := self.identities.AddAlias(line.Nick, nick)
+			switch {
+			case err != nil:
+				self.Conn.Notice(line.Nick, err.Error())
+			case ok:
+				self.Conn.Notice(line.Nick, fmt.Sprintf("Okay, %v is now linked to you", nick))
+			default:
+				self.Conn.Notice(line.Nick, fmt.Sprintf("Okay, ask %v to type -alias confirm %v",
+					nick, line.Nick))
+			}
+		case "confirm":
+			if err := self.identities.ConfirmAlias(line.Nick, nick); err != nil {
+				self.Conn.Notice(line.Nick, err.Error())
+
+				return
+			}
+
+			self.Conn.Notice(line.Nick, fmt.Sprintf("Okay, you are now linked to %v", nick))
+		case "rem":
+			if err := self.identities.RemoveAlias(line.Nick, nick); err != nil {
+				self.Conn.Notice(line.Nick, err.Error())
+
+				return
+			}
+
+			self.Conn.Notice(line.Nick, fmt.Sprintf("Okay, %v is no longer linked to you", nick))
+		default:
+			if groups["account"] != "" {
+				acct, err := self.identities.BindAccount(line.Nick)
+				if err != nil {
+					self.Conn.Notice(line.Nick, err.Error())
+
+					return
+				}
+
+				self.Conn.Notice(line.Nick, fmt.Sprintf("Okay, the NickServ account %v is now linked to you",
+					acct))
+
+				return
+			}
+
+			aliases := self.identities.Aliases(line.Nick)
+			if len(aliases) == 0 {
+				self.Conn.Notice(line.Nick, "You don't have any aliases. Link one with -alias add <nick>")
+
+				return
+			}
+
+			self.Conn.Notice(line.Nick, fmt.Sprintf("You (%v) are also known as %v",
+				self.Resolve(line.Nick), strings.Join(aliases, ", ")))
+		}
+	})
+}
@@ -0,0 +1,25 @@
+package identity
+
+import (
+	"os"
+
+	"github.com/BurntSushi/toml"
+)
+
+type config struct {
+	Identity struct {
+		Accounts bool `toml:"accounts"` // Request account-notify and extended-join
+	}
+}
+
+func (self *Module) loadConfig(fileName string) error {
+	conf := config{}
+
+	if _, err := toml.DecodeFile(fileName, &conf); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	self.useAccounts = conf.Identity.Accounts
+
+	return nil
+}
@@ -0,0 +1,394 @@
+package identity
+
+import (
+	"encoding/gob"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/crimsonvoid/ayuko/modules/store"
+)
+
+// Identities maps the nicks a person uses onto one identity, named after the
+// (lowercased) nick it was first seen as
+type Identities struct {
+	aliases  map[string]string // map[alias]identity, linked with -alias
+	accounts map[string]string // map[NickServ account]identity
+
+	session  map[string]string // map[nick]identity followed through NICK changes
+	nickAcct map[string]string // map[nick]NickServ account
+	pending  map[string]string // map[alias]identity awaiting -alias confirm
+
+	db      *store.Store // Open between Start() and Exit()
+	dataDir string
+
+	mut sync.RWMutex
+}
+
+// identitiesFile is the on-disk layout of Identities
+type identitiesFile struct {
+	Aliases  map[string]string
+	Accounts map[string]string
+}
+
+func NewIdentities(dataDir string) *Identities {
+	return &Identities{
+		dataDir: dataDir,
+
+		aliases:  make(map[string]string),
+		accounts: make(map[string]string),
+
+		session:  make(map[string]string),
+		nickAcct: make(map[string]string),
+		pending:  make(map[string]string),
+	}
+}
+
+// Start() opens the store and loads every alias and account, importing a
+// legacy identities.gob the first time
+func (self *Identities) Start() error {
+	db, err := store.Open(self.dataDir + dbName)
+	if err != nil {
+		return err
+	}
+
+	self.mut.Lock()
+	self.db = db
+	self.mut.Unlock()
+
+	if err := self.migrate(); err != nil {
+		return err
+	}
+
+	aliases, err := loadBucket(db, aliasesBucket)
+	if err != nil {
+		return err
+	}
+	accounts, err := loadBucket(db, accountsBucket)
+	if err != nil {
+		return err
+	}
+
+	self.mut.Lock()
+	self.aliases = aliases
+	self.accounts = accounts
+	self.mut.Unlock()
+
+	return nil
+}
+
+// Exit() closes the store. Every change has already been written to it
+func (self *Identities) Exit() error {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	if self.db == nil {
+		return nil
+	}
+
+	err := self.db.Close()
+	self.db = nil
+
+	return err
+}
+
+// migrate() imports identities.gob into an empty store
+func (self *Identities) migrate() error {
+	for _, bucket := range []string{aliasesBucket, accountsBucket} {
+		if n, err := self.db.Len(bucket); err != nil || n > 0 {
+			return err
+		}
+	}
+
+	if _, err := os.Stat(self.dataDir + legacyFile); err != nil {
+		return nil
+	}
+
+	if err := self.Load(legacyFile); err != nil {
+		return err
+	}
+
+	return os.Rename(self.dataDir+legacyFile, self.dataDir+legacyFile+".imported")
+}
+
+// Save() writes a snapshot of every alias and account to fileName
+func (self *Identities) Save(fileName string) error {
+	self.mut.RLock()
+	defer self.mut.RUnlock()
+
+	return store.WriteGob(self.dataDir+fileName, identitiesFile{
+		Aliases:  self.aliases,
+		Accounts: self.accounts,
+	})
+}
+
+// Load() replaces every alias and account with the snapshot in fileName
+func (self *Identities) Load(fileName string) error {
+	file, err := os.Open(self.dataDir + fileName)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	idFile := identitiesFile{}
+
+	idDec := gob.NewDecoder(file)
+	if err := idDec.Decode(&idFile); err != nil {
+		return err
+	}
+
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	if idFile.Aliases == nil {
+		idFile.Aliases = make(map[string]string)
+	}
+	if idFile.Accounts == nil {
+		idFile.Accounts = make(map[string]string)
+	}
+
+	if err := self.db.Replace(aliasesBucket, storedMap(idFile.Aliases)); err != nil {
+		return err
+	}
+	if err := self.db.Replace(accountsBucket, storedMap(idFile.Accounts)); err != nil {
+		return err
+	}
+
+	self.aliases = idFile.Aliases
+	self.accounts = idFile.Accounts
+
+	return nil
+}
+
+// Resolve() returns the identity nick currently belongs to. Nicks that are
+// not linked to anything are their own identity
+func (self *Identities) Resolve(nick string) string {
+	self.mut.RLock()
+	defer self.mut.RUnlock()
+
+	return self.resolve(strings.ToLower(nick))
+}
+
+// resolve() does not lock. The callee should hold a lock
+func (self *Identities) resolve(nick string) string {
+	if acct, ok := self.nickAcct[nick]; ok {
+		if id, ok := self.accounts[acct]; ok {
+			return id
+		}
+	}
+
+	if id, ok := self.session[nick]; ok {
+		return id
+	}
+	if id, ok := self.aliases[nick]; ok {
+		return id
+	}
+
+	return nick
+}
+
+// Aliases() returns every nick linked to nick's identity
+func (self *Identities) Aliases(nick string) []string {
+	self.mut.RLock()
+	defer self.mut.RUnlock()
+
+	id := self.resolve(strings.ToLower(nick))
+	aliases := make([]string, 0, 5)
+
+	for alias, aliasId := range self.aliases {
+		if aliasId == id {
+			aliases = append(aliases, alias)
+		}
+	}
+
+	return aliases
+}
+
+// NickChange() carries the identity of old over to new
+func (self *Identities) NickChange(old, new string) {
+	old, new = strings.ToLower(old), strings.ToLower(new)
+
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	id := self.resolve(old)
+
+	if acct, ok := self.nickAcct[old]; ok {
+		self.nickAcct[new] = acct
+		delete(self.nickAcct, old)
+	}
+
+	delete(self.session, old)
+	if id != new {
+		self.session[new] = id
+	}
+}
+
+// Quit() forgets everything learned about nick's current session
+func (self *Identities) Quit(nick string) {
+	nick = strings.ToLower(nick)
+
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	delete(self.session, nick)
+	delete(self.nickAcct, nick)
+}
+
+// SetAccount() records the NickServ account nick is logged in to. An empty
+// account or "*" means logged out
+func (self *Identities) SetAccount(nick, account string) {
+	nick, account = strings.ToLower(nick), strings.ToLower(account)
+
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	if account == "" || account == "*" {
+		delete(self.nickAcct, nick)
+
+		return
+	}
+
+	self.nickAcct[nick] = account
+}
+
+// BindAccount() links nick's current NickServ account to its identity
+func (self *Identities) BindAccount(nick string) (string, error) {
+	nick = strings.ToLower(nick)
+
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	acct, ok := self.nickAcct[nick]
+	if !ok {
+		return "", errNoAccount
+	}
+
+	if id, ok := self.accounts[acct]; ok && id != self.resolve(nick) {
+		return "", errAccountTaken
+	}
+
+	id := self.resolve(nick)
+	if err := self.db.Put(accountsBucket, acct, id); err != nil {
+		return "", err
+	}
+	self.accounts[acct] = id
+
+	return acct, nil
+}
+
+// AddAlias() links alias to nick's identity. Links to a nick that is not
+// already following nick (through a NICK change) wait for confirmation from
+// alias; ok is false in that case
+func (self *Identities) AddAlias(nick, alias string) (ok bool, err error) {
+	nick, alias = strings.ToLower(nick), strings.ToLower(alias)
+
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	id := self.resolve(nick)
+
+	if aliasId, linked := self.aliases[alias]; linked {
+		if aliasId == id {
+			return true, nil
+		}
+
+		return false, errAliasTaken
+	}
+	if alias == id || self.isIdentity(alias) {
+		return false, errAliasTaken
+	}
+
+	if self.session[alias] == id {
+		if err := self.db.Put(aliasesBucket, alias, id); err != nil {
+			return false, err
+		}
+		self.aliases[alias] = id
+
+		return true, nil
+	}
+
+	self.pending[alias] = id
+
+	return false, nil
+}
+
+// ConfirmAlias() accepts a pending link from alias to id
+func (self *Identities) ConfirmAlias(alias, id string) error {
+	alias = strings.ToLower(alias)
+
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	id = self.resolve(strings.ToLower(id))
+
+	if self.pending[alias] != id {
+		return errNoPending
+	}
+
+	if err := self.db.Put(aliasesBucket, alias, id); err != nil {
+		return err
+	}
+	delete(self.pending, alias)
+	self.aliases[alias] = id
+
+	return nil
+}
+
+func (self *Identities) RemoveAlias(nick, alias string) error {
+	nick, alias = strings.ToLower(nick), strings.ToLower(alias)
+
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	if aliasId, ok := self.aliases[alias]; !ok || aliasId != self.resolve(nick) {
+		return errNotAlias
+	}
+
+	if err := self.db.Delete(aliasesBucket, alias); err != nil {
+		return err
+	}
+	delete(self.aliases, alias)
+
+	return nil
+}
+
+// isIdentity() does not lock. The callee should hold a lock
+func (self *Identities) isIdentity(nick string) bool {
+	for _, id := range self.aliases {
+		if id == nick {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadBucket() reads a bucket of string values into a map
+func loadBucket(db *store.Store, bucket string) (map[string]string, error) {
+	values := make(map[string]string)
+
+	err := db.ForEach(bucket, func(key string, dec store.Decoder) error {
+		value := ""
+		if err := dec(&value); err != nil {
+			return err
+		}
+		values[key] = value
+
+		return nil
+	})
+
+	return values, err
+}
+
+// storedMap() converts values for store.Replace()
+func storedMap(values map[string]string) map[string]interface{} {
+	stored := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		stored[key] = value
+	}
+
+	return stored
+}
@@ -0,0 +1,50 @@
+package identity
+
+import (
+	"strings"
+
+	"github.com/crimsonvoid/ayuko/modules/store"
+	"github.com/crimsonvoid/irclib/module"
+)
+
+// New() creates an identity module configured by confFile that keeps its
+// data in dataDir, or defaultDataDir if it is empty
+func New(confFile, dataDir string) (*Module, error) {
+	mod, err := module.New(confFile)
+	if err != nil {
+		return nil, err
+	}
+
+	self := &Module{
+		Module:     mod,
+		identities: NewIdentities(store.DataDir(dataDir, defaultDataDir)),
+	}
+
+	if err := self.loadConfig(confFile); err != nil {
+		return nil, err
+	}
+
+	self.registerCommands()
+
+	return self, nil
+}
+
+// Resolve() returns the identity nick belongs to, following NICK changes,
+// aliases and NickServ accounts. The result is lowercase and suitable as a
+// map key. A nil Module treats every nick as its own identity
+func (self *Module) Resolve(nick string) string {
+	if self == nil {
+		return strings.ToLower(nick)
+	}
+
+	return self.identities.Resolve(nick)
+}
+
+// Aliases() returns every nick linked to the identity nick belongs to
+func (self *Module) Aliases(nick string) []string {
+	if self == nil {
+		return nil
+	}
+
+	return self.identities.Aliases(nick)
+}
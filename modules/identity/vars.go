@@ -0,0 +1,43 @@
+package identity
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/crimsonvoid/ayuko/modules/raw"
+	"github.com/crimsonvoid/irclib/module"
+)
+
+const (
+	defaultDataDir = "./data/identity/"
+	dbName         = "identity.db"
+	legacyFile     = "identities.gob"
+
+	aliasesBucket  = "aliases"
+	accountsBucket = "accounts"
+
+	nickR = `[\w{}\[\]^|` + "`" + `-]+`
+)
+
+var (
+	aliasR = regexp.MustCompile(fmt.Sprintf(`(?i)^-alias( (?P<action>add|rem|confirm) (?P<nick>%v)| (?P<account>account))?\s*$`,
+		nickR))
+)
+
+var (
+	errNoAccount    = errors.New("You are not logged in to NickServ")
+	errAccountTaken = errors.New("That account is already linked to someone else")
+	errAliasTaken   = errors.New("That nick is already linked to someone else")
+	errNoPending    = errors.New("There is no pending alias for you to confirm")
+	errNotAlias     = errors.New("That nick is not one of your aliases")
+)
+
+// Module is one instance of the identity module
+type Module struct {
+	*module.Module
+
+	identities  *Identities
+	events      raw.Handlers // NICK, QUIT, JOIN and ACCOUNT
+	useAccounts bool         // Request account-notify and extended-join
+}
@@ -8,17 +8,17 @@ import (
 	irc "github.com/fluffle/goirc/client"
 )
 
-func registerCommands() {
-	regMagicBall()
+func (self *Module) registerCommands() {
+	self.regMagicBall()
 }
 
-func regMagicBall() {
+func (self *Module) regMagicBall() {
 	re := regexp.MustCompile(`^(-|\.)8ball .*`)
 
-	Module.Register(module.E_PRIVMSG, re, func(line *irc.Line) {
-		index := rng.Intn(len(replies))
+	self.Register(module.E_PRIVMSG, re, func(line *irc.Line) {
+		index := self.rng.Intn(len(replies))
 
-		Module.Conn.Privmsg(
+		self.Conn.Privmsg(
 			line.Target(),
 			fmt.Sprintf("%v: %v", line.Nick, replies[index]))
 	})
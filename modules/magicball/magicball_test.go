@@ -0,0 +1,36 @@
+package magicball
+
+import (
+	"testing"
+
+	"github.com/crimsonvoid/ayuko/harness"
+)
+
+func TestMagicBall(t *testing.T) {
+	mod, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bot := harness.StartModule(t, mod.Module)
+
+	msgs, err := bot.Say("alice", harness.Channel, "-8ball will it work?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Target != harness.Channel {
+		t.Fatalf("got %v; want one reply in %v", msgs, harness.Channel)
+	}
+
+	found := false
+	for _, reply := range replies {
+		found = found || msgs[0].Text == "alice: "+reply
+	}
+	if !found {
+		t.Errorf("got %q; want alice: and one of the replies", msgs[0].Text)
+	}
+
+	if msgs, _ := bot.Say("alice", harness.Channel, "the 8ball says no", 0); len(msgs) != 0 {
+		t.Errorf("8ball mid-line: got %v; want no reply", msgs)
+	}
+}
@@ -2,15 +2,18 @@ package magicball
 
 import (
 	"math/rand"
-	"time"
 
 	"github.com/crimsonvoid/irclib/module"
 )
 
-var (
-	Module *module.Module
-	rng    = rand.New(rand.NewSource(time.Now().UnixNano()))
+// Module is one instance of the magicball module
+type Module struct {
+	*module.Module
+
+	rng *rand.Rand
+}
 
+var (
 	replies = []string{
 		"Yes",
 		"No",
@@ -0,0 +1,65 @@
+// Package raw hooks IRC commands that irclib has no module.Event for, such as
+// NICK, JOIN and ACCOUNT, onto the goirc connection a module is given
+package raw
+
+import (
+	"strings"
+	"sync"
+
+	irc "github.com/fluffle/goirc/client"
+)
+
+// Handlers are one module's raw command handlers. Attach() them from the
+// module's Preconnect, once irclib has given it a connection, and Detach()
+// them from its Disconnect so reconnecting does not add them twice
+type Handlers struct {
+	handlers []handler
+	removers []irc.Remover
+	mut      sync.Mutex
+}
+
+type handler struct {
+	cmd string
+	fn  func(*irc.Line)
+}
+
+// Handle() calls fn with every line of the command cmd, eg "NICK" or "353"
+func (self *Handlers) Handle(cmd string, fn func(*irc.Line)) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	self.handlers = append(self.handlers, handler{strings.ToUpper(cmd), fn})
+}
+
+// Attach() adds the handlers to conn
+func (self *Handlers) Attach(conn *irc.Conn) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	self.detach()
+
+	for _, h := range self.handlers {
+		fn := h.fn
+
+		self.removers = append(self.removers, conn.HandleFunc(h.cmd, func(_ *irc.Conn, line *irc.Line) {
+			fn(line)
+		}))
+	}
+}
+
+// Detach() removes the handlers from the connection they were attached to
+func (self *Handlers) Detach() {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	self.detach()
+}
+
+// detach() does not lock. The callee should hold a lock
+func (self *Handlers) detach() {
+	for _, remover := range self.removers {
+		remover.Remove()
+	}
+
+	self.removers = nil
+}
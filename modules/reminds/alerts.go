@@ -1,59 +1,494 @@
 package reminds
 
 import (
+	"encoding/gob"
+	"os"
+	"sort"
 	"sync"
+	"time"
+
+	"github.com/crimsonvoid/ayuko/modules/store"
 )
 
 type alert struct {
-	alert []*Message
-	mut   sync.RWMutex
+	mod     *Module
+	channel string
+	alert   []*Message // Pending alerts sorted by Expire
+	sending []*Message // Expired one-shot alerts not yet delivered
+	mut     sync.RWMutex
 
 	quit    chan bool
+	done    chan bool     // Closed to stop delivering
 	Expired chan *Message // Public chan to route messages out
-	expChan chan *Message // For internal use. <-Expired <-expChan <-*Message
+	expChan chan *Message // For internal use. New alerts to be scheduled
+}
+
+type Alerts struct {
+	alerts map[string]*alert
+	closed bool // Between Exit() and Start(); Add() only stores alerts
+	mut    sync.Mutex
+
+	// Called from each channel's delivery goroutine with expired alerts
+	deliver func(channel string, msg *Message)
+
+	mod *Module
+}
+
+func NewAlerts(mod *Module) *Alerts {
+	return &Alerts{
+		alerts: make(map[string]*alert),
+		mod:    mod,
+	}
+}
+
+// storedAlert is a single alert as kept in the store, keyed by Id
+type storedAlert struct {
+	Channel string
+	Msg     Message
+}
+
+// Start() schedules the alerts in the store, importing a legacy alerts.gob
+// the first time
+func (self *Alerts) Start() error {
+	self.mut.Lock()
+	self.closed = false
+	self.mut.Unlock()
+
+	if n, err := self.mod.db.Len(alertsBucket); err != nil {
+		return err
+	} else if n == 0 {
+		if _, err := os.Stat(self.mod.dataDir + "alerts.gob"); err == nil {
+			if err := self.Load("alerts.gob"); err != nil {
+				return err
+			}
+
+			return os.Rename(self.mod.dataDir+"alerts.gob", self.mod.dataDir+"alerts.gob.imported")
+		}
+	}
+
+	alertMap := make(map[string][]*Message)
+
+	err := self.mod.db.ForEach(alertsBucket, func(id string, dec store.Decoder) error {
+		stored := storedAlert{}
+		if err := dec(&stored); err != nil {
+			return err
+		}
+
+		msg := stored.Msg
+		alertMap[stored.Channel] = append(alertMap[stored.Channel], &msg)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Reminds have claimed their ids already
+	for _, msgs := range alertMap {
+		for _, msg := range msgs {
+			if !self.mod.msgIds.Claim(msg.Id) {
+				self.mod.db.Delete(alertsBucket, msg.Id)
+				msg.Id = ""
+			}
+		}
+	}
+
+	self.addAll(alertMap)
+
+	return nil
+}
+
+// Exit() stops every scheduler. Pending alerts, and expired ones not yet
+// delivered, are already in the store
+func (self *Alerts) Exit() error {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	self.closed = true
+
+	for channel := range self.alerts {
+		self.stopAlert(channel)
+	}
+	self.alerts = make(map[string]*alert)
+
+	return nil
+}
+
+// Clear() stops and removes every alert. Alerts added afterwards are
+// scheduled as before
+func (self *Alerts) Clear() error {
+	self.mut.Lock()
+
+	for channel := range self.alerts {
+		self.stopAlert(channel)
+	}
+	for _, msgs := range self.copy() {
+		for _, msg := range msgs {
+			self.mod.msgIds.Release(msg.Id)
+		}
+	}
+	self.alerts = make(map[string]*alert)
+
+	self.mut.Unlock()
+
+	self.mod.snapshots.Changed()
+
+	return self.mod.db.Replace(alertsBucket, nil)
+}
+
+// Restore() replaces every alert with those in alertMap
+func (self *Alerts) Restore(alertMap map[string][]*Message) error {
+	if err := self.Clear(); err != nil {
+		return err
+	}
+
+	for _, msgs := range alertMap {
+		for _, msg := range msgs {
+			if msg.Id != "" && !self.mod.msgIds.Claim(msg.Id) {
+				msg.Id = ""
+			}
+		}
+	}
+
+	self.addAll(alertMap)
+
+	return nil
+}
+
+// Load() adds the alerts in the legacy alerts file fileName
+func (self *Alerts) Load(fileName string) error {
+	file, err := os.Open(self.mod.dataDir + fileName)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	alertMap := make(map[string][]*Message)
+
+	alertsDec := gob.NewDecoder(file)
+	if err := alertsDec.Decode(&alertMap); err != nil {
+		return err
+	}
+
+	self.addAll(alertMap)
+
+	return nil
+}
+
+// addAll() adds every alert in alertMap
+func (self *Alerts) addAll(alertMap map[string][]*Message) {
+	// Give the bot time to (re)join channels before firing alerts that
+	// expired while it was offline
+	grace := time.Now().UTC().Add(alertGrace)
+
+	for channel, msgs := range alertMap {
+		for _, msg := range msgs {
+			if msg.Expire.Before(grace) {
+				msg.Expire = grace
+			}
+
+			self.Add(channel, msg)
+		}
+	}
+}
+
+// Schedules msg to be delivered to channel once it expires
+func (self *Alerts) Add(channel string, msg *Message) {
+	if msg.Id == "" {
+		msg.Id = self.mod.msgIds.New()
+	} else {
+		self.mod.msgIds.Claim(msg.Id)
+	}
+
+	self.mod.persistAlert(channel, msg)
+
+	// Hold the lock while handing msg over so Exit() can not stop the
+	// scheduler in between. Once closed, Start() schedules msg from the store
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	if self.closed {
+		return
+	}
+
+	self.startAlert(channel).expChan <- msg
+}
+
+// Copy() returns a snapshot of all pending alerts keyed by channel
+func (self *Alerts) Copy() map[string][]*Message {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	return self.copy()
+}
+
+// copy() does not lock. The callee should hold a lock. Expired alerts not
+// yet delivered are included
+func (self *Alerts) copy() map[string][]*Message {
+	alertMap := make(map[string][]*Message, len(self.alerts))
+
+	for channel, a := range self.alerts {
+		a.mut.RLock()
+		if len(a.alert)+len(a.sending) > 0 {
+			msgs := make([]*Message, 0, len(a.alert)+len(a.sending))
+			for _, msg := range append(append([]*Message{}, a.sending...), a.alert...) {
+				msgCopy := *msg
+				msgs = append(msgs, &msgCopy)
+			}
+			alertMap[channel] = msgs
+		}
+		a.mut.RUnlock()
+	}
+
+	return alertMap
+}
+
+// Pending() returns the alerts set by owner
+func (self *Alerts) Pending(owner string) []Pending {
+	pending := make([]Pending, 0, 5)
+
+	for channel, msgs := range self.Copy() {
+		for _, msg := range msgs {
+			if msg.Owner == owner {
+				pending = append(pending, Pending{ChanNick{channel, msg.To}, *msg})
+			}
+		}
+	}
+
+	return pending
+}
+
+func (self *Alerts) Cancel(owner, id string) (Pending, bool) {
+	return self.update(owner, id, func(a *alert, i int) {
+		self.mod.unpersistAlert(a.alert[i])
+		a.alert = append(a.alert[:i], a.alert[i+1:]...)
+	})
 }
 
-func newAlerts() map[string]*alert {
-	alerts := make(map[string]*alert)
+func (self *Alerts) Edit(owner, id, text string) (Pending, bool) {
+	return self.update(owner, id, func(a *alert, i int) {
+		a.alert[i].Message = text
+		self.mod.persistAlert(a.channel, a.alert[i])
+	})
+}
+
+// Snooze() pushes an alert back by d. The scheduler picks up the new expiry
+// the next time it wakes
+func (self *Alerts) Snooze(owner, id string, d time.Duration) (Pending, bool) {
+	return self.update(owner, id, func(a *alert, i int) {
+		a.alert[i].snooze(d)
+		self.mod.persistAlert(a.channel, a.alert[i])
+		sort.Sort(msgList(a.alert))
+	})
+}
+
+// update() finds owner's alert id and calls fn with the alert's lock held
+func (self *Alerts) update(owner, id string, fn func(a *alert, i int)) (Pending, bool) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	for channel, a := range self.alerts {
+		a.mut.Lock()
 
-	return alerts
+		for i, msg := range a.alert {
+			if msg.Id != id || msg.Owner != owner {
+				continue
+			}
+
+			fn(a, i)
+			pending := Pending{ChanNick{channel, msg.To}, *msg}
+			a.mut.Unlock()
+
+			return pending, true
+		}
+
+		a.mut.Unlock()
+	}
+
+	return Pending{}, false
 }
 
-// TODO - Map locks
-func startAlert(channel string) <-chan *Message {
-	a, ok := alerts[channel]
+// startAlert() does not lock. The callee should hold a lock
+func (self *Alerts) startAlert(channel string) *alert {
+	a, ok := self.alerts[channel]
 	if ok {
-		return a.Expired
+		return a
 	}
 
 	a = &alert{
-		alert: make([]*Message, 0, 5),
+		mod:     self.mod,
+		channel: channel,
+		alert:   make([]*Message, 0, 5),
 
 		quit:    make(chan bool),
+		done:    make(chan bool),
 		Expired: make(chan *Message, 5),
 		expChan: make(chan *Message, 5),
 	}
 
+	go a.schedule()
+
 	go func() {
 		for {
 			select {
-			case alrt := <-a.expChan:
-				a.Expired <- alrt
-				// TODO - Delete alrt
-			case <-a.quit:
+			case msg := <-a.Expired:
+				if self.deliver != nil {
+					self.deliver(channel, msg)
+				}
+				a.delivered(msg)
+			case <-a.done:
 				return
 			}
 		}
 	}()
 
-	alerts[channel] = a
+	self.alerts[channel] = a
 
-	return a.Expired
+	return a
 }
 
-func stopAlert(channel string) {
-	a := alerts[channel]
+// stopAlert() does not lock. The callee should hold a lock
+func (self *Alerts) stopAlert(channel string) {
+	a, ok := self.alerts[channel]
+	if !ok {
+		return
+	}
+
 	a.quit <- true
-	close(a.Expired)
-	alerts[channel] = a
+	close(a.done)
+
+	// Keep alerts that were queued but not yet scheduled so they are saved
+	for {
+		select {
+		case msg := <-a.expChan:
+			a.insert(msg)
+		default:
+			return
+		}
+	}
+}
+
+// schedule() waits for the earliest pending alert to expire and routes it
+// out through Expired until quit is signalled
+func (self *alert) schedule() {
+	timer := time.NewTimer(self.next())
+	defer timer.Stop()
+
+	for {
+		select {
+		case msg := <-self.expChan:
+			self.insert(msg)
+		case <-timer.C:
+			for _, msg := range self.popExpired() {
+				self.Expired <- msg
+			}
+		case <-self.quit:
+			return
+		}
+
+		timer.Stop()
+		timer.Reset(self.next())
+	}
+}
+
+func (self *alert) insert(msg *Message) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	self.alert = append(self.alert, msg)
+	sort.Sort(msgList(self.alert))
+}
+
+// popExpired() removes expired alerts, rescheduling recurring ones
+func (self *alert) popExpired() []*Message {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	now := time.Now().UTC()
+	i := 0
+
+	for ; i < len(self.alert); i++ {
+		if self.alert[i].Expire.After(now) {
+			break
+		}
+	}
+
+	expired := make([]*Message, 0, i)
+	pending := self.alert[i:]
+
+	for _, msg := range self.alert[:i] {
+		// One-shot alerts stay stored until delivered()
+		if msg.Repeat == nil {
+			self.sending = append(self.sending, msg)
+			expired = append(expired, msg)
+
+			continue
+		}
+
+		// Deliver a copy and reschedule; missed occurrences are collapsed
+		delivered := *msg
+		expired = append(expired, &delivered)
+
+		msg.Set = now
+		msg.Expire = msg.Repeat.Next(now)
+		self.mod.persistAlert(self.channel, msg)
+		pending = append(pending, msg)
+	}
+
+	self.alert = pending
+	sort.Sort(msgList(self.alert))
+
+	return expired
+}
+
+// delivered() forgets msg once it has been delivered
+func (self *alert) delivered(msg *Message) {
+	if msg.Repeat != nil {
+		return
+	}
+
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	for i, sending := range self.sending {
+		if sending == msg {
+			self.sending = append(self.sending[:i], self.sending[i+1:]...)
+			break
+		}
+	}
+
+	self.mod.unpersistAlert(msg)
+}
+
+// Duration until the next alert expires
+func (self *alert) next() time.Duration {
+	self.mut.RLock()
+	defer self.mut.RUnlock()
+
+	if len(self.alert) == 0 {
+		return alertIdle
+	}
+
+	return self.alert[0].Expire.Sub(time.Now().UTC())
+}
+
+// persistAlert() commits msg, scheduled for channel, to the store
+func (self *Module) persistAlert(channel string, msg *Message) {
+	if err := self.db.Put(alertsBucket, msg.Id, storedAlert{channel, *msg}); err != nil {
+		self.Logger.Errorf("Error storing alert %v: %v\n", msg.Id, err)
+	}
+
+	self.snapshots.Changed()
+}
+
+func (self *Module) unpersistAlert(msg *Message) {
+	self.msgIds.Release(msg.Id)
+
+	if err := self.db.Delete(alertsBucket, msg.Id); err != nil {
+		self.Logger.Errorf("Error deleting alert %v: %v\n", msg.Id, err)
+	}
+
+	self.snapshots.Changed()
 }
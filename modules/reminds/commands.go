@@ -4,22 +4,85 @@ import (
 	"fmt"
 	"log"
 	"regexp"
-	"strconv"
 	"strings"
+	"time"
 
+	"github.com/crimsonvoid/ayuko/modules/store"
 	"github.com/crimsonvoid/irclib/module"
 	irc "github.com/fluffle/goirc/client"
 )
 
-func registerCommands() {
-	Module.Preconnect = reminds.Start
-	Module.Disconnect = reminds.Exit
+func (self *Module) registerCommands() {
+	self.Preconnect = func() error {
+		self.msgIds.Reset()
+		self.events.Attach(self.Conn)
 
-	regComAddRemind()
-	regComGetRemind()
+		var err error
+		if self.db, err = store.Open(self.dataDir + dbName); err != nil {
+			return err
+		}
+
+		for _, start := range []func() error{
+			self.timezones.Start, self.deliveries.Start, self.reminds.Start, self.alerts.Start, self.online.Start,
+			self.snapshots.Start,
+		} {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+	self.Disconnect = func() error {
+		var err error
+
+		self.events.Detach()
+
+		for _, exit := range []func() error{
+			// The final snapshot is written before alerts stop and forget their schedules
+			self.online.Exit, self.snapshots.Exit, self.alerts.Exit, self.timezones.Exit, self.deliveries.Exit,
+		} {
+			if exitErr := exit(); exitErr != nil {
+				self.Logger.Errorf("Error saving reminds data: %v\n", exitErr)
+				err = exitErr
+			}
+		}
+
+		if closeErr := self.db.Close(); closeErr != nil {
+			self.Logger.Errorf("Error closing reminds store: %v\n", closeErr)
+			err = closeErr
+		}
+		self.db = nil
+
+		return err
+	}
+
+	self.alerts.deliver = func(channel string, msg *Message) {
+		if isChannel(msg.To) {
+			self.Conn.Privmsg(channel, fmt.Sprintf("Reminder from %s: %s", msg.From, msg.Message))
+
+			return
+		}
+
+		self.Conn.Privmsg(channel, fmt.Sprintf("%s: %s wanted me to remind you %s",
+			msg.To, msg.From, msg.Message))
+	}
+
+	self.regComAddRemind()
+	self.regComGetRemind()
+	self.regComPresence()
+	self.regComAddAlert()
+	self.regComTimezone()
+	self.regComDelivery()
+	self.regComListReminds()
+	self.regComManageRemind()
 
 	errFns := []func() error{
-		regConsPrintRems,
+		self.regConsPrintRems,
+		self.regConsSnapshots,
+		self.regConsRestore,
+		self.regConsExport,
+		self.regConsImport,
 	}
 
 	for _, errFn := range errFns {
@@ -29,20 +92,46 @@ func registerCommands() {
 	}
 }
 
-func regComAddRemind() {
-	Module.Register(module.E_PRIVMSG, remindsR, func(line *irc.Line) {
+func (self *Module) regComAddRemind() {
+	self.Register(module.E_PRIVMSG, remindsR, func(line *irc.Line) {
 		lineText := line.Text()
+		if manageR.MatchString(lineText) {
+			return
+		}
+
 		groups, _ := matchGroups(remindsR, lineText)
 		nicks := getNicks(groups["ids"])
-		duration, message := strings.ToLower(groups["duration"]), groups["message"]
+		offset, message := groups["offset"], groups["message"]
+		via := strings.ToLower(groups["via"])
+
+		loc := self.timezones.Location(line.Nick)
+
+		var (
+			expire time.Time
+			repeat *Recurrence
+			err    error
+		)
+
+		switch {
+		case groups["when"] != "":
+			expire, err = ParseWhen(groups["when"], time.Now().In(loc))
+		case groups["interval"] != "" || groups["days"] != "":
+			if owned := self.countRecurring(line.Nick); owned+len(nicks) > self.maxRecurring {
+				self.Conn.Notice(line.Nick, fmt.Sprintf("I'm sorry, you can only have %v recurring reminds. "+
+					"Use -reminds and -remind cancel <id> to remove some", self.maxRecurring))
+
+				return
+			}
+
+			repeat, err = ParseRecurrence(groups["interval"], groups["days"], time.Now().In(loc))
+			if err == nil {
+				expire = repeat.Next(time.Now())
+			}
+		}
 
-		timeN, err := strconv.Atoi(groups["time"])
-		if groups["time"] == "" {
-			timeN = 0
-		} else if err != nil {
-			Module.Logger.Errorf("Could not convert `%v` to an int: %v\n  %v\n",
-				groups["time"], err, line)
-			Module.Conn.Notice(line.Nick, "I'm sorry, there was an error parsing your remind")
+		if err != nil {
+			self.Logger.Errorf("Error parsing remind time: %v\n  %v\n", err, lineText)
+			self.Conn.Notice(line.Nick, fmt.Sprintf("I'm sorry, %v", err))
 
 			return
 		}
@@ -58,9 +147,15 @@ func regComAddRemind() {
 				to, from = strings.ToLower(line.Nick), "You"
 			}
 
-			rem, err := ParseMessage(from, duration, message, timeN)
+			if !expire.IsZero() {
+				msgs[to] = NewMessage(from, to, message, time.Now(), expire)
+
+				continue
+			}
+
+			rem, err := ParseMessage(from, to, offset, message)
 			if err != nil {
-				Module.Logger.Errorf("Error parsing remind: %v\n  %v\n",
+				self.Logger.Errorf("Error parsing remind: %v\n  %v\n",
 					err, lineText)
 
 				break
@@ -71,33 +166,49 @@ func regComAddRemind() {
 
 		// Only add if all Messages were parsed without an error
 		if len(msgs) != len(nicks) {
-			Module.Conn.Notice(line.Nick, "I'm sorry, there was an error parsing your remind")
+			self.Conn.Notice(line.Nick, "I'm sorry, there was an error parsing your remind")
 
 			return
 		}
 
 		toS := make([]string, 0, len(msgs))
+		ids := make([]string, 0, len(msgs))
 		chn := strings.ToLower(line.Target())
+		owner := self.ids.Resolve(line.Nick)
 		var to string
 		var msg *Message
 
 		for to, msg = range msgs {
-			reminds.Add(ChanNick{chn, to}, msg)
+			msg.Owner = owner
+			msg.Repeat = repeat
+			msg.Delivery = via
+
+			if isChannel(to) {
+				self.alerts.Add(to, msg)
+			} else {
+				self.reminds.Add(ChanNick{chn, self.ids.Resolve(to)}, msg)
+			}
 
-			if to == strings.ToLower(line.Nick) {
+			ids = append(ids, msg.Id)
+
+			if self.ids.Resolve(to) == owner {
 				to = "you"
 			}
 
 			toS = append(toS, to)
 		}
 
-		timeMsg := "unkown (nil)"
-		if msg != nil {
-			timeMsg = fmt.Sprintf("%v (%v)",
-				msg.Expire.Sub(msg.Set), msg.Expire.Format(timeFormat),
+		timeMsg := "in unkown (nil)"
+		if msg != nil && repeat != nil {
+			timeMsg = fmt.Sprintf("%v (next: %v)",
+				repeat, msg.Expire.In(loc).Format(timeFormat),
+			)
+		} else if msg != nil {
+			timeMsg = fmt.Sprintf("in %v (%v)",
+				fmtDuration(msg.Expire.Sub(msg.Set)), msg.Expire.In(loc).Format(timeFormat),
 			)
 		} else {
-			Module.Logger.Errorf("`msg` is nil, this should not happen!\n  Line: %v\n  Parsed Messages: %v\n",
+			self.Logger.Errorf("`msg` is nil, this should not happen!\n  Line: %v\n  Parsed Messages: %v\n",
 				lineText, msgs)
 		}
 
@@ -110,29 +221,328 @@ func regComAddRemind() {
 			whom = fmt.Sprintf("%v, and %v", strings.Join(toS[:toLen], ", "), toS[toLen])
 		}
 
-		Module.Conn.Privmsg(line.Target(), fmt.Sprintf("Okay I'll remind %v about that in %v.",
-			whom, timeMsg,
+		self.Conn.Privmsg(line.Target(), fmt.Sprintf("Okay I'll remind %v about that %v. [%v]",
+			whom, timeMsg, strings.Join(ids, ", "),
 		))
 	})
 }
 
-func regComGetRemind() {
+func (self *Module) regComGetRemind() {
 	re := regexp.MustCompile(`.*`)
 
-	Module.Register(module.E_PRIVMSG, re, func(line *irc.Line) {
-		rems := reminds.GetExpired(ChanNick{strings.ToLower(line.Target()),
-			strings.ToLower(line.Nick)})
+	self.Register(module.E_PRIVMSG, re, func(line *irc.Line) {
+		if line.Public() {
+			self.online.Join(line.Target(), line.Nick)
+		}
+		self.deliverMessage(line.Target(), line.Nick)
+	})
+}
+
+// regComPresence() tracks who is in the bot's channels for join and private
+// delivery, starting with the NAMES of every channel joined
+func (self *Module) regComPresence() {
+	self.events.Handle("353", func(line *irc.Line) {
+		// RPL_NAMREPLY: me = #channel :nick @op +voice
+		if len(line.Args) < 4 {
+			return
+		}
+
+		for _, nick := range strings.Fields(line.Text()) {
+			if nick = strings.TrimLeft(nick, namesPrefixes); nick != "" {
+				self.online.Join(line.Args[2], nick)
+			}
+		}
+	})
+
+	self.events.Handle("JOIN", func(line *irc.Line) {
+		if self.isMe(line.Nick) {
+			// NAMES follows with everyone already there
+			return
+		}
+
+		self.online.Join(line.Target(), line.Nick)
+		self.deliverJoined(line.Target(), line.Nick)
+	})
+
+	self.events.Handle("PART", func(line *irc.Line) {
+		self.parted(line.Target(), line.Nick)
+	})
+
+	self.events.Handle("KICK", func(line *irc.Line) {
+		if len(line.Args) >= 2 {
+			self.parted(line.Args[0], line.Args[1])
+		}
+	})
 
-		for _, rem := range rems {
-			Module.Conn.Privmsg(line.Target(), fmt.Sprintf("Oh %s! %s wanted me to remind you %s",
-				line.Nick, rem.From, rem.Message))
+	self.events.Handle("NICK", func(line *irc.Line) {
+		if len(line.Args) == 0 {
+			return
 		}
+
+		self.online.Rename(line.Nick, line.Args[0])
+		self.deliverPrivate(line.Args[0])
+	})
+
+	self.events.Handle("QUIT", func(line *irc.Line) {
+		self.online.Quit(line.Nick)
 	})
 }
 
-func regConsPrintRems() error {
-	err := Module.Console.Register("list", func(string) {
-		log.Println(reminds.String())
+// parted() forgets nick in channel, or the whole channel if the bot left it
+func (self *Module) parted(channel, nick string) {
+	if self.isMe(nick) {
+		self.online.Leave(channel)
+	} else {
+		self.online.Part(channel, nick)
+	}
+}
+
+func (self *Module) regComAddAlert() {
+	self.Register(module.E_PRIVMSG, alertsR, func(line *irc.Line) {
+		lineText := line.Text()
+		groups, _ := matchGroups(alertsR, lineText)
+		offset, message := groups["offset"], groups["message"]
+
+		to, from, whom := groups["nick"], line.Nick, groups["nick"]
+		if strings.ToLower(to) == "me" {
+			to, from, whom = line.Nick, "You", "you"
+		}
+
+		alrt, err := ParseMessage(from, to, offset, message)
+		if err != nil {
+			self.Logger.Errorf("Error parsing alert: %v\n  %v\n", err, lineText)
+			self.Conn.Notice(line.Nick, "I'm sorry, there was an error parsing your alert")
+
+			return
+		}
+
+		alrt.Owner = self.ids.Resolve(line.Nick)
+		self.alerts.Add(strings.ToLower(line.Target()), alrt)
+
+		self.Conn.Privmsg(line.Target(), fmt.Sprintf("Okay I'll alert %v about that in %v (%v). [%v]",
+			whom, fmtDuration(alrt.Expire.Sub(alrt.Set)), alrt.Expire.In(self.timezones.Location(line.Nick)).Format(timeFormat),
+			alrt.Id,
+		))
+	})
+}
+
+func (self *Module) regComListReminds() {
+	self.Register(module.E_PRIVMSG, pendingR, func(line *irc.Line) {
+		loc := self.timezones.Location(line.Nick)
+
+		pending := self.listPending(line.Nick)
+		if len(pending) == 0 {
+			self.Conn.Notice(line.Nick, "You don't have any pending reminds")
+
+			return
+		}
+
+		for _, p := range pending {
+			self.Conn.Notice(line.Nick, fmt.Sprintf("[%v] %v", p.Id, fmtPending(p, loc)))
+		}
+	})
+}
+
+func (self *Module) regComManageRemind() {
+	self.Register(module.E_PRIVMSG, manageR, func(line *irc.Line) {
+		groups, _ := matchGroups(manageR, line.Text())
+		loc := self.timezones.Location(line.Nick)
+
+		var (
+			p   Pending
+			err error
+			msg string
+		)
+
+		switch strings.ToLower(groups["action"]) {
+		case "cancel":
+			p, err = self.cancelRemind(line.Nick, groups["id"])
+			msg = "Cancelled"
+		case "edit":
+			if strings.TrimSpace(groups["arg"]) == "" {
+				err = fmt.Errorf("Syntax: -remind edit <id> <new text>")
+				break
+			}
+
+			p, err = self.editRemind(line.Nick, groups["id"], groups["arg"])
+			msg = "Updated"
+		case "snooze":
+			var d time.Duration
+			if d, err = ParseDuration(groups["arg"]); err != nil {
+				break
+			}
+			if d <= 0 {
+				err = fmt.Errorf("Syntax: -remind snooze <id> <duration>")
+				break
+			}
+
+			p, err = self.snoozeRemind(line.Nick, groups["id"], d)
+			msg = "Snoozed"
+		}
+
+		if err != nil {
+			self.Conn.Notice(line.Nick, err.Error())
+
+			return
+		}
+
+		self.Conn.Notice(line.Nick, fmt.Sprintf("%v [%v] %v", msg, p.Id, fmtPending(p, loc)))
+	})
+}
+
+func fmtPending(p Pending, loc *time.Location) string {
+	when := fmt.Sprintf("in %v (%v)",
+		fmtDuration(p.Expire.Sub(time.Now())), p.Expire.In(loc).Format(timeFormat))
+	if p.Repeat != nil {
+		when = fmt.Sprintf("%v (next: %v)", p.Repeat, p.Expire.In(loc).Format(timeFormat))
+	}
+
+	to := p.Key.Nick
+	if p.Key.Channel != p.Key.Nick {
+		to = fmt.Sprintf("%v in %v", p.Key.Nick, p.Key.Channel)
+	}
+
+	if p.Delivery != "" {
+		when += " via " + p.Delivery
+	}
+
+	return fmt.Sprintf("%v %v: %v", to, when, p.Message.Message)
+}
+
+func (self *Module) regComDelivery() {
+	self.Register(module.E_PRIVMSG, deliveryR, func(line *irc.Line) {
+		groups, _ := matchGroups(deliveryR, line.Text())
+
+		switch {
+		case groups["policy"] != "":
+			self.deliveries.Set(line.Nick, groups["policy"])
+
+			self.Conn.Notice(line.Nick, fmt.Sprintf("Okay, I'll deliver your reminds by %v",
+				self.deliveries.Policy(line.Nick)))
+		case groups["unset"] != "":
+			self.deliveries.Remove(line.Nick)
+
+			self.Conn.Notice(line.Nick, fmt.Sprintf("Okay, your reminds are delivered by %v again",
+				self.deliveries.Policy(line.Nick)))
+		default:
+			self.Conn.Notice(line.Nick, fmt.Sprintf("Your reminds are delivered by %v. "+
+				"Change it with -delivery set <channel|join|anywhere|pm>", self.deliveries.Policy(line.Nick)))
+		}
+	})
+}
+
+func (self *Module) regComTimezone() {
+	self.Register(module.E_PRIVMSG, tzR, func(line *irc.Line) {
+		groups, _ := matchGroups(tzR, line.Text())
+
+		switch {
+		case groups["zone"] != "":
+			loc, err := self.timezones.Set(line.Nick, groups["zone"])
+			if err != nil {
+				self.Conn.Notice(line.Nick, fmt.Sprintf("Sorry, I don't know the timezone %v. "+
+					"Try a name like Europe/Berlin or America/New_York", groups["zone"]))
+
+				return
+			}
+
+			self.Conn.Notice(line.Nick, fmt.Sprintf("Okay, your timezone is now %v (%v)",
+				loc, time.Now().In(loc).Format(timeFormat)))
+		case groups["unset"] != "":
+			self.timezones.Remove(line.Nick)
+
+			self.Conn.Notice(line.Nick, fmt.Sprintf("Okay, your timezone is back to the default %v",
+				self.defaultLoc))
+		default:
+			loc := self.timezones.Location(line.Nick)
+
+			self.Conn.Notice(line.Nick, fmt.Sprintf("Your timezone is %v (%v). "+
+				"Change it with -tz set <zone>", loc, time.Now().In(loc).Format(timeFormat)))
+		}
+	})
+}
+
+func (self *Module) regConsPrintRems() error {
+	err := self.Console.Register("list", func(string) {
+		log.Println(self.reminds.String())
+	})
+
+	return err
+}
+
+func (self *Module) regConsSnapshots() error {
+	err := self.Console.Register("snapshots", func(string) {
+		snaps, err := self.snapshots.List()
+		if err != nil {
+			log.Printf("Error listing snapshots: %v\n", err)
+
+			return
+		}
+
+		for _, snap := range snaps {
+			log.Println(snap.Name)
+		}
+	})
+
+	return err
+}
+
+func (self *Module) regConsRestore() error {
+	re := regexp.MustCompile(`^restore (?P<file>.+)$`)
+	err := self.Console.Register(re, func(s string) {
+		groups, _ := matchGroups(re, s)
+
+		if err := self.snapshots.Restore(groups["file"]); err != nil {
+			errMsg := fmt.Sprintf("Error restoring %v: %v", groups["file"], err)
+			self.Logger.Errorln(errMsg)
+			log.Println(errMsg)
+
+			return
+		}
+
+		log.Printf("Restored reminds from %v\n", groups["file"])
+	})
+
+	return err
+}
+
+func (self *Module) regConsExport() error {
+	re := regexp.MustCompile(`^export (?P<file>\S+)$`)
+	err := self.Console.Register(re, func(s string) {
+		groups, _ := matchGroups(re, s)
+
+		if err := self.exportReminds(groups["file"]); err != nil {
+			errMsg := fmt.Sprintf("Error exporting %v: %v", groups["file"], err)
+			self.Logger.Errorln(errMsg)
+			log.Println(errMsg)
+
+			return
+		}
+
+		log.Printf("Exported reminds to %v\n", groups["file"])
+	})
+
+	return err
+}
+
+func (self *Module) regConsImport() error {
+	re := regexp.MustCompile(`^import (?P<file>\S+)( (?P<mode>merge|replace))?( (?P<dry>dry))?$`)
+	err := self.Console.Register(re, func(s string) {
+		groups, _ := matchGroups(re, s)
+
+		if groups["mode"] == "" {
+			groups["mode"] = store.Merge
+		}
+
+		report, err := self.importReminds(groups["file"], groups["mode"], groups["dry"] != "")
+		if err != nil {
+			errMsg := fmt.Sprintf("Error importing %v: %v", groups["file"], err)
+			self.Logger.Errorln(errMsg)
+			log.Println(errMsg)
+
+			return
+		}
+
+		log.Println(report)
 	})
 
 	return err
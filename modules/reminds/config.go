@@ -0,0 +1,38 @@
+package reminds
+
+import (
+	"os"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/crimsonvoid/ayuko/modules/store"
+)
+
+type config struct {
+	Reminds struct {
+		Timezone     string `toml:"timezone"`      // Default timezone for absolute remind times
+		MaxRecurring int    `toml:"max_recurring"` // Recurring reminds one nick may own
+
+		store.SnapshotConfig
+	}
+}
+
+func (self *Module) loadConfig(fileName string) error {
+	conf := config{}
+
+	if _, err := toml.DecodeFile(fileName, &conf); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	loc, err := time.LoadLocation(conf.Reminds.Timezone)
+	if err != nil {
+		return err
+	}
+	self.defaultLoc = loc
+
+	if conf.Reminds.MaxRecurring > 0 {
+		self.maxRecurring = conf.Reminds.MaxRecurring
+	}
+
+	return conf.Reminds.Apply(self.snapshots)
+}
@@ -0,0 +1,276 @@
+package reminds
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Delivery policies for reminds
+const (
+	deliverChannel  = "channel"  // Next message in the channel the remind was set in
+	deliverJoin     = "join"     // Same as channel, or on joining that channel
+	deliverAnywhere = "anywhere" // Next message in any channel the bot shares
+	deliverPM       = "pm"       // Private message as soon as the nick is seen online
+)
+
+type Deliveries struct {
+	nickPrefs // map[nick]delivery policy
+}
+
+func NewDeliveries(mod *Module) *Deliveries {
+	return &Deliveries{newNickPrefs(mod, "delivery.gob")}
+}
+
+func (self *Deliveries) Set(nick, policy string) {
+	self.set(nick, strings.ToLower(policy))
+}
+
+// Policy() returns nick's preferred delivery policy, defaulting to
+// deliverChannel
+func (self *Deliveries) Policy(nick string) string {
+	if policy, ok := self.get(nick); ok {
+		return policy
+	}
+
+	return deliverChannel
+}
+
+// policyFor() resolves the delivery policy of msg sent to key.Nick
+func (self *Module) policyFor(key ChanNick, msg *Message) string {
+	if msg.Delivery != "" {
+		return msg.Delivery
+	}
+
+	return self.deliveries.Policy(key.Nick)
+}
+
+// presence tracks the (lowercased) nicks in each channel the bot is in. A nick
+// is online while it shares at least one channel with the bot
+type presence struct {
+	channels map[string]map[string]bool
+	mut      sync.RWMutex
+
+	mod  *Module
+	quit chan bool
+}
+
+func newPresence(mod *Module) *presence {
+	return &presence{
+		channels: make(map[string]map[string]bool),
+		mod:      mod,
+	}
+}
+
+func (self *presence) Join(channel, nick string) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	channel = strings.ToLower(channel)
+	if self.channels[channel] == nil {
+		self.channels[channel] = make(map[string]bool)
+	}
+	self.channels[channel][strings.ToLower(nick)] = true
+}
+
+func (self *presence) Part(channel, nick string) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	delete(self.channels[strings.ToLower(channel)], strings.ToLower(nick))
+}
+
+// Leave() forgets everyone in channel, for when the bot itself leaves it
+func (self *presence) Leave(channel string) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	delete(self.channels, strings.ToLower(channel))
+}
+
+// Quit() removes nick from every channel
+func (self *presence) Quit(nick string) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	nick = strings.ToLower(nick)
+	for _, nicks := range self.channels {
+		delete(nicks, nick)
+	}
+}
+
+func (self *presence) Rename(oldNick, newNick string) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	oldNick, newNick = strings.ToLower(oldNick), strings.ToLower(newNick)
+	for _, nicks := range self.channels {
+		if nicks[oldNick] {
+			delete(nicks, oldNick)
+			nicks[newNick] = true
+		}
+	}
+}
+
+// Online() reports whether nick is in any of the bot's channels
+func (self *presence) Online(nick string) bool {
+	self.mut.RLock()
+	defer self.mut.RUnlock()
+
+	nick = strings.ToLower(nick)
+	for _, nicks := range self.channels {
+		if nicks[nick] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Nicks() returns every online nick once
+func (self *presence) Nicks() []string {
+	self.mut.RLock()
+	defer self.mut.RUnlock()
+
+	seen := make(map[string]bool)
+	nicks := make([]string, 0, len(self.channels))
+
+	for _, members := range self.channels {
+		for nick := range members {
+			if !seen[nick] {
+				seen[nick] = true
+				nicks = append(nicks, nick)
+			}
+		}
+	}
+
+	return nicks
+}
+
+// clear() forgets every channel. Membership does not survive a reconnect
+func (self *presence) clear() {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	self.channels = make(map[string]map[string]bool)
+}
+
+// Start() periodically delivers expired private reminds to online nicks
+func (self *presence) Start() error {
+	self.clear()
+	self.quit = make(chan bool)
+
+	go func(quit chan bool) {
+		ticker := time.NewTicker(pmInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				for _, nick := range self.Nicks() {
+					self.mod.deliverPrivate(nick)
+				}
+			case <-quit:
+				return
+			}
+		}
+	}(self.quit)
+
+	return nil
+}
+
+func (self *presence) Exit() error {
+	if self.quit != nil {
+		close(self.quit)
+		self.quit = nil
+	}
+
+	self.clear()
+
+	return nil
+}
+
+// deliverMessage() sends reminds due when nick speaks in channel
+func (self *Module) deliverMessage(channel, nick string) {
+	lchan := strings.ToLower(channel)
+
+	rems := self.reminds.GetExpiredFor(self.ids.Resolve(nick), func(key ChanNick, msg *Message) bool {
+		switch self.policyFor(key, msg) {
+		case deliverAnywhere, deliverPM:
+			return true
+		default:
+			return key.Channel == lchan
+		}
+	})
+
+	self.send(channel, nick, rems)
+}
+
+// deliverJoined() sends reminds due when nick joins channel
+func (self *Module) deliverJoined(channel, nick string) {
+	lchan := strings.ToLower(channel)
+
+	rems := self.reminds.GetExpiredFor(self.ids.Resolve(nick), func(key ChanNick, msg *Message) bool {
+		switch self.policyFor(key, msg) {
+		case deliverJoin:
+			return key.Channel == lchan
+		case deliverPM:
+			return true
+		}
+
+		return false
+	})
+
+	self.send(channel, nick, rems)
+}
+
+// deliverPrivate() sends nick's expired reminds that are delivered by PM,
+// while nick is online
+func (self *Module) deliverPrivate(nick string) {
+	if !self.online.Online(nick) {
+		return
+	}
+
+	rems := self.reminds.GetExpiredFor(self.ids.Resolve(nick), func(key ChanNick, msg *Message) bool {
+		return self.policyFor(key, msg) == deliverPM
+	})
+
+	self.send(nick, nick, rems)
+}
+
+// send() delivers rems to nick in channel, or privately for deliverPM. Reminds
+// that cannot be sent, because the bot is offline, are put back
+func (self *Module) send(channel, nick string, rems []Pending) {
+	for i, rem := range rems {
+		if !self.Conn.Connected() {
+			self.restore(rems[i:])
+
+			return
+		}
+
+		if self.policyFor(rem.Key, &rem.Message) == deliverPM {
+			self.Conn.Privmsg(nick, fmt.Sprintf("Hey %s! %s wanted me to remind you %s (set in %s)",
+				nick, rem.From, rem.Message.Message, rem.Key.Channel))
+
+			continue
+		}
+
+		self.Conn.Privmsg(channel, fmt.Sprintf("Oh %s! %s wanted me to remind you %s",
+			nick, rem.From, rem.Message.Message))
+	}
+}
+
+// restore() puts back one-shot reminds taken by GetExpiredFor() but not sent.
+// They are due again at once. Recurring reminds were already rescheduled
+func (self *Module) restore(rems []Pending) {
+	for _, rem := range rems {
+		if rem.Repeat != nil {
+			continue
+		}
+
+		msg := rem.Message
+		msg.duration = time.After(0)
+		self.reminds.Add(rem.Key, &msg)
+	}
+}
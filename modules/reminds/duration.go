@@ -0,0 +1,108 @@
+package reminds
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseDuration sums every number/unit pair in offset ("1h30m", "2 days 4
+// hours", "1.5h", "1h, 30m and 10s"). Anything but spaces, commas and "and"
+// between the pairs is an error. An empty offset is a zero duration.
+func ParseDuration(offset string) (time.Duration, error) {
+	var total float64
+
+	lower := strings.ToLower(offset)
+	end := 0
+
+	for _, loc := range pairRe.FindAllStringSubmatchIndex(lower, -1) {
+		if err := checkGap(lower[end:loc[0]], end == 0); err != nil {
+			return 0, err
+		}
+		end = loc[1]
+
+		num, err := strconv.ParseFloat(lower[loc[2]:loc[3]], 64)
+		if err != nil {
+			return 0, fmt.Errorf("Error parsing duration: `%v`", lower[loc[0]:loc[1]])
+		}
+
+		unit, err := unitDuration(lower[loc[6]:loc[7]])
+		if err != nil {
+			return 0, err
+		}
+
+		total += num * float64(unit)
+	}
+
+	if err := checkGap(lower[end:], end == 0); err != nil {
+		return 0, err
+	}
+
+	if total > math.MaxInt64 {
+		return 0, fmt.Errorf("Duration too long: `%v`", offset)
+	}
+
+	return time.Duration(total), nil
+}
+
+// checkGap() rejects text between number/unit pairs that is not a separator,
+// or before the first pair that is not a space
+func checkGap(gap string, first bool) error {
+	trimmed := strings.TrimSpace(gap)
+	if !pairSepRe.MatchString(gap) || (first && trimmed != "") {
+		return fmt.Errorf("Error parsing duration: `%v`", trimmed)
+	}
+
+	return nil
+}
+
+func unitDuration(unit string) (time.Duration, error) {
+	switch unit {
+	case "s", "sec", "secs", "second", "seconds":
+		return time.Second, nil
+	case "m", "min", "mins", "minute", "minutes":
+		return time.Minute, nil
+	case "h", "hour", "hours":
+		return time.Hour, nil
+	case "d", "day", "days":
+		return time.Hour * 24, nil
+	case "w", "week", "weeks":
+		return time.Hour * 24 * 7, nil
+	case "mo", "month", "months": // 1 month == 30 days
+		return time.Hour * 24 * 30, nil
+	case "y", "year", "years": // 8765.81 hours in a year according to Google
+		return time.Hour * 8766, nil
+	}
+
+	return 0, fmt.Errorf("Error parsing duration: `%v`", unit)
+}
+
+// fmtDuration formats d as "2d 4h 30m", dropping empty units
+func fmtDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	if d <= 0 {
+		return "0s"
+	}
+
+	units := []struct {
+		suffix string
+		size   time.Duration
+	}{
+		{"d", time.Hour * 24},
+		{"h", time.Hour},
+		{"m", time.Minute},
+		{"s", time.Second},
+	}
+
+	parts := make([]string, 0, len(units))
+	for _, unit := range units {
+		if n := d / unit.size; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d%v", n, unit.suffix))
+			d -= n * unit.size
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
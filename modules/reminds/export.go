@@ -0,0 +1,431 @@
+package reminds
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/crimsonvoid/ayuko/modules/identity"
+	"github.com/crimsonvoid/ayuko/modules/store"
+)
+
+const (
+	exportVersion = 1
+
+	kindRemind = "remind" // Delivered when To next speaks in Channel
+	kindAlert  = "alert"  // Posted to Channel when it expires
+)
+
+var (
+	exportIdRe   = regexp.MustCompile(`^[a-z0-9]+$`)
+	exportNickRe = regexp.MustCompile(`^` + nickR + `$`)
+)
+
+// exportFile is the JSON export format. Version 1:
+//
+//	{
+//	  "version": 1,
+//	  "reminds": [
+//	    {
+//	      "id": "k3x9a",
+//	      "kind": "remind",            // or "alert"
+//	      "channel": "#chan",
+//	      "to": "nick",                // a nick, or the channel for channel alerts
+//	      "from": "Nick",
+//	      "owner": "nick",             // defaults to from, lowercased
+//	      "message": "take out the bins",
+//	      "set": "2026-10-18T09:00:00Z",
+//	      "expire": "2026-10-18T18:00:00Z",
+//	      "repeat": {                  // optional
+//	        "interval": "2h",          // either an interval...
+//	        "days": ["monday"],        // ...or days at hour:minute in zone
+//	        "hour": 9, "minute": 0, "zone": "Europe/London"
+//	      },
+//	      "delivery": "pm"             // optional: channel, join, anywhere or pm
+//	    }
+//	  ]
+//	}
+//
+// Times are RFC 3339. A missing id is generated on import.
+type exportFile struct {
+	Version int            `json:"version"`
+	Reminds []exportRemind `json:"reminds"`
+}
+
+type exportRemind struct {
+	Id       string            `json:"id"`
+	Kind     string            `json:"kind"`
+	Channel  string            `json:"channel"`
+	To       string            `json:"to"`
+	From     string            `json:"from"`
+	Owner    string            `json:"owner"`
+	Message  string            `json:"message"`
+	Set      time.Time         `json:"set"`
+	Expire   time.Time         `json:"expire"`
+	Repeat   *exportRecurrence `json:"repeat,omitempty"`
+	Delivery string            `json:"delivery,omitempty"`
+}
+
+type exportRecurrence struct {
+	Interval string   `json:"interval,omitempty"`
+	Days     []string `json:"days,omitempty"`
+	Hour     int      `json:"hour"`
+	Minute   int      `json:"minute"`
+	Zone     string   `json:"zone,omitempty"`
+}
+
+// exported is a validated, importable remind or alert
+type exported struct {
+	Kind string
+	Pending
+}
+
+// exportReminds() writes every remind and alert to fileName as JSON
+func (self *Module) exportReminds(fileName string) error {
+	export := exportFile{
+		Version: exportVersion,
+		Reminds: make([]exportRemind, 0, 10),
+	}
+
+	for _, rem := range self.existingReminds() {
+		export.Reminds = append(export.Reminds, newExportRemind(rem))
+	}
+
+	sort.Sort(exportList(export.Reminds))
+
+	return store.WriteFile(self.dataDir+fileName, func(w io.Writer) error {
+		data, err := json.MarshalIndent(export, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(data)
+
+		return err
+	})
+}
+
+// importReminds() reads reminds exported by exportReminds(). In store.Merge
+// mode imported reminds are added to the existing ones, replacing any with
+// the same id. In store.Replace mode every existing remind and alert is
+// dropped first. Invalid records are skipped. With dryRun nothing is changed
+func (self *Module) importReminds(fileName, mode string, dryRun bool) (*store.ImportReport, error) {
+	data, err := ioutil.ReadFile(self.dataDir + fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	export := exportFile{}
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, err
+	}
+	if export.Version < 1 || export.Version > exportVersion {
+		return nil, fmt.Errorf("Unsupported export version %v", export.Version)
+	}
+
+	report := &store.ImportReport{Mode: mode, DryRun: dryRun}
+	existing := self.existingReminds()
+
+	imported := make([]exported, 0, len(export.Reminds))
+	seen := make(map[string]bool, len(export.Reminds))
+
+	// Ids handed out to records without one, released if nothing is imported
+	allocated := make([]string, 0)
+
+	for i, rec := range export.Reminds {
+		rem, err := rec.toExported(self.ids)
+		if err == nil && rem.Id == "" {
+			if dryRun {
+				// Never a real id, so it matches no existing remind
+				rem.Id = fmt.Sprintf("#%v", i)
+			} else {
+				rem.Id = self.msgIds.New()
+				allocated = append(allocated, rem.Id)
+			}
+		}
+		if err == nil && seen[rem.Id] {
+			err = errors.New("duplicate id")
+		}
+		if err != nil {
+			report.Reject("#%v (%v): %v", i, rec.Id, err)
+			continue
+		}
+		seen[rem.Id] = true
+
+		old, ok := existing[rem.Id]
+		switch {
+		case !ok:
+			report.Added++
+		case sameRemind(old, rem):
+			report.Unchanged++
+		default:
+			report.Conflict("%v: %q for %v -> %q for %v",
+				rem.Id, old.Message.Message, old.Message.To, rem.Message.Message, rem.Message.To)
+			report.Updated++
+		}
+
+		imported = append(imported, rem)
+	}
+
+	if mode == store.Replace {
+		for id := range existing {
+			if !seen[id] {
+				report.Removed++
+			}
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+	if self.db == nil {
+		self.msgIds.Release(allocated...)
+
+		return nil, store.ErrClosed
+	}
+
+	if mode == store.Replace {
+		if err := self.reminds.Clear(); err != nil {
+			self.msgIds.Release(allocated...)
+
+			return nil, err
+		}
+		if err := self.alerts.Clear(); err != nil {
+			self.msgIds.Release(allocated...)
+
+			return nil, err
+		}
+	}
+
+	alertMap := make(map[string][]*Message)
+
+	for _, rem := range imported {
+		if old, ok := existing[rem.Id]; ok && mode == store.Merge {
+			if sameRemind(old, rem) {
+				continue
+			}
+
+			if old.Kind == kindAlert {
+				self.alerts.Cancel(old.Owner, old.Id)
+			} else {
+				self.reminds.Cancel(old.Owner, old.Id)
+			}
+		}
+
+		msg := rem.Message
+		if rem.Kind == kindAlert {
+			alertMap[rem.Key.Channel] = append(alertMap[rem.Key.Channel], &msg)
+
+			continue
+		}
+
+		msg.duration = time.After(msg.Expire.Sub(time.Now().UTC()))
+		self.reminds.Add(rem.Key, &msg)
+	}
+
+	self.alerts.addAll(alertMap)
+
+	return report, nil
+}
+
+// existingReminds() returns every remind and alert keyed by Id
+func (self *Module) existingReminds() map[string]exported {
+	existing := make(map[string]exported)
+
+	for key, msgs := range self.reminds.Copy() {
+		for _, msg := range msgs {
+			existing[msg.Id] = exported{kindRemind, Pending{key, msg}}
+		}
+	}
+
+	for channel, msgs := range self.alerts.Copy() {
+		for _, msg := range msgs {
+			existing[msg.Id] = exported{kindAlert, Pending{ChanNick{channel, msg.To}, *msg}}
+		}
+	}
+
+	return existing
+}
+
+func sameRemind(a, b exported) bool {
+	repeatA, repeatB := "", ""
+	if a.Repeat != nil {
+		repeatA = a.Repeat.String()
+	}
+	if b.Repeat != nil {
+		repeatB = b.Repeat.String()
+	}
+
+	return a.Kind == b.Kind && a.Key == b.Key &&
+		a.From == b.From && a.Message.To == b.Message.To && a.Owner == b.Owner &&
+		a.Message.Message == b.Message.Message && a.Expire.Equal(b.Expire) &&
+		a.Delivery == b.Delivery && repeatA == repeatB
+}
+
+func newExportRemind(rem exported) exportRemind {
+	rec := exportRemind{
+		Id:       rem.Id,
+		Kind:     rem.Kind,
+		Channel:  rem.Key.Channel,
+		To:       rem.Message.To,
+		From:     rem.From,
+		Owner:    rem.Owner,
+		Message:  rem.Message.Message,
+		Set:      rem.Set,
+		Expire:   rem.Expire,
+		Delivery: rem.Delivery,
+	}
+
+	if repeat := rem.Repeat; repeat != nil {
+		rec.Repeat = &exportRecurrence{
+			Hour:   repeat.Hour,
+			Minute: repeat.Minute,
+			Zone:   repeat.Zone,
+		}
+
+		if repeat.Interval > 0 {
+			rec.Repeat.Interval = repeat.Interval.String()
+		}
+		for _, day := range repeat.Days {
+			rec.Repeat.Days = append(rec.Repeat.Days, strings.ToLower(day.String()))
+		}
+	}
+
+	return rec
+}
+
+// toExported() validates self and converts it to a remind or alert
+func (self *exportRemind) toExported(ids *identity.Module) (exported, error) {
+	rem := exported{Kind: strings.ToLower(self.Kind)}
+	channel := strings.ToLower(self.Channel)
+
+	switch {
+	case self.Id != "" && !exportIdRe.MatchString(self.Id):
+		return rem, fmt.Errorf("invalid id %q", self.Id)
+	case rem.Kind != kindRemind && rem.Kind != kindAlert:
+		return rem, fmt.Errorf("unknown kind %q", self.Kind)
+	case channel == "":
+		return rem, errors.New("missing channel")
+	case rem.Kind == kindAlert && !isChannel(channel):
+		return rem, fmt.Errorf("alert channel %q is not a channel", self.Channel)
+	case rem.Kind == kindRemind && !exportNickRe.MatchString(self.To):
+		return rem, fmt.Errorf("invalid nick %q", self.To)
+	case self.To == "":
+		return rem, errors.New("missing to")
+	case self.From == "":
+		return rem, errors.New("missing from")
+	case self.Message == "":
+		return rem, errors.New("missing message")
+	case self.Expire.IsZero():
+		return rem, errors.New("missing expire")
+	}
+
+	switch strings.ToLower(self.Delivery) {
+	case "", deliverChannel, deliverJoin, deliverAnywhere, deliverPM:
+	default:
+		return rem, fmt.Errorf("unknown delivery %q", self.Delivery)
+	}
+
+	msg := Message{
+		Id:       self.Id,
+		From:     self.From,
+		To:       self.To,
+		Owner:    ids.Resolve(self.Owner),
+		Message:  self.Message,
+		Set:      self.Set.UTC(),
+		Expire:   self.Expire.UTC(),
+		Delivery: strings.ToLower(self.Delivery),
+	}
+
+	if self.Owner == "" {
+		msg.Owner = ids.Resolve(self.From)
+	}
+	if msg.Set.IsZero() {
+		msg.Set = time.Now().UTC()
+	}
+
+	if self.Repeat != nil {
+		repeat, err := self.Repeat.toRecurrence()
+		if err != nil {
+			return rem, err
+		}
+		msg.Repeat = repeat
+	}
+
+	rem.Message = msg
+	rem.Key = ChanNick{channel, ids.Resolve(self.To)}
+	if rem.Kind == kindAlert {
+		rem.Key.Nick = msg.To
+	}
+
+	return rem, nil
+}
+
+func (self *exportRecurrence) toRecurrence() (*Recurrence, error) {
+	if self.Interval != "" {
+		interval, err := time.ParseDuration(self.Interval)
+		if err != nil {
+			return nil, err
+		}
+		if interval < minRecurrence {
+			return nil, fmt.Errorf("repeat interval %v is under %v", interval, fmtDuration(minRecurrence))
+		}
+
+		return &Recurrence{Interval: interval}, nil
+	}
+
+	if len(self.Days) == 0 {
+		return nil, errors.New("repeat needs an interval or days")
+	}
+	if self.Hour < 0 || self.Hour > 23 || self.Minute < 0 || self.Minute > 59 {
+		return nil, fmt.Errorf("invalid repeat time %02d:%02d", self.Hour, self.Minute)
+	}
+
+	repeat := &Recurrence{
+		Hour:   self.Hour,
+		Minute: self.Minute,
+		Zone:   self.Zone,
+	}
+	if repeat.Zone == "" {
+		repeat.Zone = "UTC"
+	}
+	if _, err := time.LoadLocation(repeat.Zone); err != nil {
+		return nil, err
+	}
+
+	for _, name := range self.Days {
+		name = strings.ToLower(name)
+
+		day, ok := time.Weekday(0), false
+		if len(name) >= 3 {
+			day, ok = weekdays[name[:3]]
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown repeat day %q", name)
+		}
+
+		repeat.Days = append(repeat.Days, day)
+	}
+
+	return repeat, nil
+}
+
+type exportList []exportRemind
+
+func (self exportList) Len() int {
+	return len(self)
+}
+
+func (self exportList) Less(i, j int) bool {
+	return self[i].Expire.Before(self[j].Expire)
+}
+
+func (self exportList) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
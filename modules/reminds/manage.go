@@ -0,0 +1,89 @@
+package reminds
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Pending is a remind or alert along with where it is delivered
+type Pending struct {
+	Key ChanNick
+	Message
+}
+
+// listPending() returns the reminds and alerts set by owner, soonest first
+func (self *Module) listPending(owner string) []Pending {
+	owner = self.ids.Resolve(owner)
+
+	pending := append(self.reminds.Pending(owner), self.alerts.Pending(owner)...)
+	sort.Sort(pendingList(pending))
+
+	return pending
+}
+
+func (self *Module) countRecurring(owner string) int {
+	n := 0
+
+	for _, p := range self.listPending(owner) {
+		if p.Repeat != nil {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (self *Module) cancelRemind(owner, id string) (Pending, error) {
+	owner, id = self.ids.Resolve(owner), strings.ToLower(id)
+
+	if p, ok := self.reminds.Cancel(owner, id); ok {
+		return p, nil
+	}
+	if p, ok := self.alerts.Cancel(owner, id); ok {
+		return p, nil
+	}
+
+	return Pending{}, fmt.Errorf("You don't have a remind with id %v", id)
+}
+
+func (self *Module) editRemind(owner, id, text string) (Pending, error) {
+	owner, id = self.ids.Resolve(owner), strings.ToLower(id)
+
+	if p, ok := self.reminds.Edit(owner, id, text); ok {
+		return p, nil
+	}
+	if p, ok := self.alerts.Edit(owner, id, text); ok {
+		return p, nil
+	}
+
+	return Pending{}, fmt.Errorf("You don't have a remind with id %v", id)
+}
+
+func (self *Module) snoozeRemind(owner, id string, d time.Duration) (Pending, error) {
+	owner, id = self.ids.Resolve(owner), strings.ToLower(id)
+
+	if p, ok := self.reminds.Snooze(owner, id, d); ok {
+		return p, nil
+	}
+	if p, ok := self.alerts.Snooze(owner, id, d); ok {
+		return p, nil
+	}
+
+	return Pending{}, fmt.Errorf("You don't have a remind with id %v", id)
+}
+
+type pendingList []Pending
+
+func (self pendingList) Len() int {
+	return len(self)
+}
+
+func (self pendingList) Less(i, j int) bool {
+	return self[i].Expire.Before(self[j].Expire)
+}
+
+func (self pendingList) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
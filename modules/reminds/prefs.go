@@ -0,0 +1,159 @@
+package reminds
+
+import (
+	"encoding/gob"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/crimsonvoid/ayuko/modules/store"
+)
+
+// nickPrefs is a persisted per-nick string setting
+type nickPrefs struct {
+	fileName string
+	bucket   string
+	prefs    map[string]string // map[nick]setting
+	mut      sync.RWMutex
+
+	mod *Module
+}
+
+func newNickPrefs(mod *Module, fileName string) nickPrefs {
+	return nickPrefs{
+		mod:      mod,
+		fileName: fileName,
+		bucket:   strings.TrimSuffix(fileName, ".gob"),
+		prefs:    make(map[string]string),
+	}
+}
+
+// Start() loads the settings from the store, importing a legacy gob file the
+// first time
+func (self *nickPrefs) Start() error {
+	if n, err := self.mod.db.Len(self.bucket); err != nil {
+		return err
+	} else if n == 0 {
+		if _, err := os.Stat(self.mod.dataDir + self.fileName); err == nil {
+			if err := self.Load(self.fileName); err != nil {
+				return err
+			}
+
+			return os.Rename(self.mod.dataDir+self.fileName, self.mod.dataDir+self.fileName+".imported")
+		}
+	}
+
+	prefs := make(map[string]string)
+
+	err := self.mod.db.ForEach(self.bucket, func(nick string, dec store.Decoder) error {
+		pref := ""
+		if err := dec(&pref); err != nil {
+			return err
+		}
+		prefs[nick] = pref
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	self.mut.Lock()
+	self.prefs = prefs
+	self.mut.Unlock()
+
+	return nil
+}
+
+func (self *nickPrefs) Exit() error {
+	return nil
+}
+
+// Load() replaces the settings with those in the legacy file fileName
+func (self *nickPrefs) Load(fileName string) error {
+	file, err := os.Open(self.mod.dataDir + fileName)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	prefs := make(map[string]string)
+
+	prefsDec := gob.NewDecoder(file)
+	if err := prefsDec.Decode(&prefs); err != nil {
+		return err
+	}
+
+	return self.replace(prefs)
+}
+
+// replace() replaces every setting with prefs
+func (self *nickPrefs) replace(prefs map[string]string) error {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	if prefs == nil {
+		prefs = make(map[string]string)
+	}
+
+	stored := make(map[string]interface{}, len(prefs))
+	for nick, pref := range prefs {
+		stored[nick] = pref
+	}
+	self.prefs = prefs
+	self.mod.snapshots.Changed()
+
+	return self.mod.db.Replace(self.bucket, stored)
+}
+
+// copy() returns every setting
+func (self *nickPrefs) copy() map[string]string {
+	self.mut.RLock()
+	defer self.mut.RUnlock()
+
+	prefs := make(map[string]string, len(self.prefs))
+	for nick, pref := range self.prefs {
+		prefs[nick] = pref
+	}
+
+	return prefs
+}
+
+func (self *nickPrefs) get(nick string) (string, bool) {
+	self.mut.RLock()
+	defer self.mut.RUnlock()
+
+	pref, ok := self.prefs[self.mod.ids.Resolve(nick)]
+
+	return pref, ok
+}
+
+func (self *nickPrefs) set(nick, pref string) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	nick = self.mod.ids.Resolve(nick)
+	self.prefs[nick] = pref
+
+	if err := self.mod.db.Put(self.bucket, nick, pref); err != nil {
+		self.mod.Logger.Errorf("Error storing %v for %v: %v\n", self.bucket, nick, err)
+	}
+
+	self.mod.snapshots.Changed()
+}
+
+func (self *nickPrefs) Remove(nick string) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	nick = self.mod.ids.Resolve(nick)
+	delete(self.prefs, nick)
+
+	if err := self.mod.db.Delete(self.bucket, nick); err != nil {
+		self.mod.Logger.Errorf("Error deleting %v for %v: %v\n", self.bucket, nick, err)
+	}
+
+	self.mod.snapshots.Changed()
+}
@@ -0,0 +1,130 @@
+package reminds
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Recurrence describes when a recurring reminder fires again. A reminder
+// either repeats on a fixed Interval, or at Hour:Minute (in Zone) on each of
+// Days.
+type Recurrence struct {
+	Interval time.Duration
+
+	Days         []time.Weekday
+	Hour, Minute int
+	Zone         string
+}
+
+// ParseRecurrence parses the interval ("2h", "1 day") or the day spec ("day at
+// 9am", "weekday at 10", "monday at 10:00") that followed "every". Day specs
+// without a clock time keep the time of day of now, which also supplies the
+// timezone.
+func ParseRecurrence(interval, days string, now time.Time) (*Recurrence, error) {
+	if interval != "" {
+		every, err := ParseDuration(interval)
+		if err != nil {
+			return nil, err
+		}
+		if every < minRecurrence {
+			return nil, fmt.Errorf("reminders can repeat at most every %v", fmtDuration(minRecurrence))
+		}
+
+		return &Recurrence{Interval: every}, nil
+	}
+
+	days = strings.ToLower(days)
+	repeat := &Recurrence{
+		Hour:   now.Hour(),
+		Minute: now.Minute(),
+		Zone:   now.Location().String(),
+	}
+
+	switch {
+	case strings.HasPrefix(days, "weekday"):
+		repeat.Days = []time.Weekday{
+			time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday,
+		}
+	case strings.HasPrefix(days, "day"):
+		repeat.Days = []time.Weekday{
+			time.Sunday, time.Monday, time.Tuesday, time.Wednesday,
+			time.Thursday, time.Friday, time.Saturday,
+		}
+	case weekdayRe.MatchString(days):
+		groups, _ := matchGroups(weekdayRe, days)
+		repeat.Days = []time.Weekday{weekdays[groups["weekday"][:3]]}
+	default:
+		return nil, fmt.Errorf("Error parsing recurrence: `%v`", days)
+	}
+
+	if clockRe.MatchString(days) {
+		groups, _ := matchGroups(clockRe, days)
+
+		var err error
+		if repeat.Hour, repeat.Minute, err = parseClock(groups); err != nil {
+			return nil, err
+		}
+	}
+
+	return repeat, nil
+}
+
+// Next() returns the first occurrence strictly after after
+func (self *Recurrence) Next(after time.Time) time.Time {
+	if self.Interval > 0 {
+		return after.Add(self.Interval).UTC()
+	}
+
+	loc, err := time.LoadLocation(self.Zone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	local := after.In(loc)
+	year, month, day := local.Date()
+
+	for i := 0; i <= 7; i++ {
+		next := time.Date(year, month, day+i, self.Hour, self.Minute, 0, 0, loc)
+		if !next.After(after) || !self.onDay(next.Weekday()) {
+			continue
+		}
+
+		return next.UTC()
+	}
+
+	// Days is empty; treat as daily so the reminder is never lost
+	return time.Date(year, month, day+1, self.Hour, self.Minute, 0, 0, loc).UTC()
+}
+
+func (self *Recurrence) onDay(wd time.Weekday) bool {
+	for _, day := range self.Days {
+		if day == wd {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (self *Recurrence) String() string {
+	if self.Interval > 0 {
+		return "every " + fmtDuration(self.Interval)
+	}
+
+	days := ""
+	switch len(self.Days) {
+	case 7:
+		days = "day"
+	case 5:
+		days = "weekday"
+	default:
+		names := make([]string, 0, len(self.Days))
+		for _, day := range self.Days {
+			names = append(names, day.String())
+		}
+		days = strings.Join(names, ", ")
+	}
+
+	return fmt.Sprintf("every %v at %02d:%02d %v", days, self.Hour, self.Minute, self.Zone)
+}
@@ -1,24 +1,55 @@
 package reminds
 
 import (
-	"fmt"
-	"path/filepath"
-	"runtime"
+	"time"
 
+	"github.com/crimsonvoid/ayuko/modules/identity"
+	"github.com/crimsonvoid/ayuko/modules/store"
 	"github.com/crimsonvoid/irclib/module"
 )
 
-func init() {
-	_, file, _, _ := runtime.Caller(0)
-	base := filepath.Base(file)
-	ext := filepath.Ext(base)
-	var err error
-
-	Module, err = module.New(fmt.Sprintf("data%[1]cconfs%[1]c%v.toml",
-		filepath.Separator, base[:len(base)-len(ext)]))
+// New() creates a reminds module configured by confFile that keeps its data
+// in dataDir, or defaultDataDir if it is empty. Nicks are resolved through
+// ids
+func New(confFile, dataDir string, ids *identity.Module) (*Module, error) {
+	mod, err := module.New(confFile)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	self := &Module{
+		Module: mod,
+
+		ids:     ids,
+		dataDir: store.DataDir(dataDir, defaultDataDir),
+
+		defaultLoc:   time.UTC,
+		maxRecurring: defaultMaxRecurring,
 	}
+	self.msgIds = newIdSource()
+	self.reminds = NewReminds(self)
+	self.alerts = NewAlerts(self)
+	self.timezones = NewTimezones(self)
+	self.deliveries = NewDeliveries(self)
+	self.online = newPresence(self)
+	self.snapshots = &store.Snapshots{
+		Dir:  self.dataDir,
+		Save: self.saveSnapshot,
+		Load: self.loadSnapshot,
+		Log: func(format string, v ...interface{}) {
+			self.Logger.Errorf(format, v...)
+		},
+
+		Interval: defaultAutosave,
+		Debounce: defaultDebounce,
+		Keep:     store.Retention{Hourly: 24, Daily: 7, Monthly: 12},
+	}
+
+	if err := self.loadConfig(confFile); err != nil {
+		return nil, err
+	}
+
+	self.registerCommands()
 
-	registerCommands()
+	return self, nil
 }
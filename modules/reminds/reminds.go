@@ -9,15 +9,21 @@ import (
 	"sync"
 	"time"
 
+	"github.com/crimsonvoid/ayuko/modules/store"
 	"github.com/crimsonvoid/console/styles"
 )
 
 type Message struct {
+	Id      string // Short identifier users refer to the remind by
 	From    string
+	To      string
+	Owner   string // Lowercased nick of whoever set the remind
 	Message string
 
 	Set      time.Time
 	Expire   time.Time
+	Repeat   *Recurrence // nil for one-shot reminds
+	Delivery string      // Delivery policy; empty to use the recipient's
 	duration <-chan time.Time
 }
 
@@ -28,57 +34,90 @@ type ChanNick struct {
 
 type Reminds struct {
 	msgMap map[ChanNick][]*Message
+	byNick map[string][]ChanNick // map[nick]keys in msgMap, so deliveries skip other nicks
 	mut    sync.RWMutex
+
+	mod *Module
+}
+
+// remindsFile is the on-disk layout of Reminds. Version 1 files are a bare
+// map[ChanNick][]*Message and are upgraded by Load()
+type remindsFile struct {
+	Version int
+	Reminds map[ChanNick][]*Message
 }
 
-func NewReminds() Reminds {
-	return Reminds{
+func NewReminds(mod *Module) *Reminds {
+	return &Reminds{
 		msgMap: make(map[ChanNick][]*Message),
+		byNick: make(map[string][]ChanNick),
+		mod:    mod,
 	}
 }
 
-func (self *Reminds) Start() error {
-	return self.Load("reminds.gob")
+// storedRemind is a single remind as kept in the store, keyed by Id
+type storedRemind struct {
+	Key ChanNick
+	Msg Message
 }
 
-func (self *Reminds) Exit() error {
-	timeStamp := time.Now().UTC()
-
-	timedPath := fmt.Sprintf("%v-%02[2]d %[2]v", timeStamp.Year(), timeStamp.Month())
-	if err := os.MkdirAll(dataDir+timedPath, 755); err != nil {
+// Start() loads reminds from the store, importing a legacy reminds.gob the
+// first time
+func (self *Reminds) Start() error {
+	if n, err := self.mod.db.Len(remindsBucket); err != nil {
 		return err
-	}
-	timedFileName := fmt.Sprintf("%v/%02v_(%02v.%02v).gob",
-		timedPath, timeStamp.Day(), timeStamp.Hour(), timeStamp.Minute())
+	} else if n == 0 {
+		if _, err := os.Stat(self.mod.dataDir + "reminds.gob"); err == nil {
+			if err := self.Load("reminds.gob"); err != nil {
+				return err
+			}
 
-	if err := self.Save("reminds.gob"); err != nil {
-		return err
-	}
-	if err := self.Save(timedFileName); err != nil {
-		return err
+			return os.Rename(self.mod.dataDir+"reminds.gob", self.mod.dataDir+"reminds.gob.imported")
+		}
 	}
 
-	return nil
-}
+	msgMap := make(map[ChanNick][]*Message)
+	now := time.Now().UTC()
+
+	err := self.mod.db.ForEach(remindsBucket, func(id string, dec store.Decoder) error {
+		rem := storedRemind{}
+		if err := dec(&rem); err != nil {
+			return err
+		}
+
+		msg := rem.Msg
+		msg.duration = time.After(msg.Expire.Sub(now))
+		msgMap[rem.Key] = append(msgMap[rem.Key], &msg)
 
-func (self *Reminds) Save(fileName string) error {
-	file, err := os.Create(dataDir + fileName)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	self.mut.RLock()
-	defer self.mut.RUnlock()
+	self.mut.Lock()
+	defer self.mut.Unlock()
 
-	codesEnc := gob.NewEncoder(file)
-	err = codesEnc.Encode(self.msgMap)
+	self.msgMap = msgMap
+	self.reindex()
 
-	return err
+	// Alerts claim their ids after this, so only reminds can collide here
+	for key, msgs := range msgMap {
+		for _, msg := range msgs {
+			if !self.mod.msgIds.Claim(msg.Id) {
+				self.mod.db.Delete(remindsBucket, msg.Id)
+				msg.Id = self.mod.msgIds.New()
+				self.persist(key, msg)
+			}
+		}
+	}
+
+	return nil
 }
 
+// Load() replaces all reminds with those in the legacy reminds file fileName
 func (self *Reminds) Load(fileName string) error {
-	file, err := os.Open(dataDir + fileName)
+	file, err := os.Open(self.mod.dataDir + fileName)
 	if os.IsNotExist(err) {
 		return nil
 	} else if err != nil {
@@ -86,56 +125,119 @@ func (self *Reminds) Load(fileName string) error {
 	}
 	defer file.Close()
 
+	remFile := remindsFile{}
+
+	codesDec := gob.NewDecoder(file)
+	if err = codesDec.Decode(&remFile); err != nil {
+		// Version 1; a bare map
+		if _, err := file.Seek(0, 0); err != nil {
+			return err
+		}
+
+		remFile.Version = 1
+		codesDec = gob.NewDecoder(file)
+		if err = codesDec.Decode(&remFile.Reminds); err != nil {
+			return err
+		}
+	}
+
+	return self.replace(remFile)
+}
+
+// replace() replaces all reminds with those in remFile, upgrading earlier
+// versions
+func (self *Reminds) replace(remFile remindsFile) error {
 	self.mut.Lock()
 	defer self.mut.Unlock()
 
-	codesDec := gob.NewDecoder(file)
-	err = codesDec.Decode(&self.msgMap)
+	self.releaseIds()
 
-	for _, msgs := range self.msgMap {
+	self.msgMap = make(map[ChanNick][]*Message)
+	if remFile.Reminds != nil {
+		self.msgMap = remFile.Reminds
+	}
+	self.reindex()
+	self.mod.snapshots.Changed()
+
+	now := time.Now().UTC()
+	stored := make(map[string]interface{})
+
+	for key, msgs := range self.msgMap {
 		for _, msg := range msgs {
-			msg.duration = time.After(msg.Expire.Sub(msg.Set))
+			if remFile.Version < 2 {
+				msg.To = key.Nick
+
+				msg.Owner = strings.ToLower(msg.From)
+				if msg.From == "You" {
+					msg.Owner = key.Nick
+				}
+			}
+			if msg.Id == "" || !self.mod.msgIds.Claim(msg.Id) {
+				msg.Id = self.mod.msgIds.New()
+			}
+
+			msg.duration = time.After(msg.Expire.Sub(now))
+			stored[msg.Id] = storedRemind{key, *msg}
 		}
 	}
 
-	return err
+	return self.mod.db.Replace(remindsBucket, stored)
 }
 
-func ParseMessage(from, duration, msg string, timeN int) (*Message, error) {
+// Clear() removes every remind
+func (self *Reminds) Clear() error {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	self.releaseIds()
+	self.msgMap = make(map[ChanNick][]*Message)
+	self.reindex()
+	self.mod.snapshots.Changed()
+
+	return self.mod.db.Replace(remindsBucket, nil)
+}
+
+// persist() commits msg to the store
+func (self *Reminds) persist(key ChanNick, msg *Message) {
+	if err := self.mod.db.Put(remindsBucket, msg.Id, storedRemind{key, *msg}); err != nil {
+		self.mod.Logger.Errorf("Error storing remind %v: %v\n", msg.Id, err)
+	}
+
+	self.mod.snapshots.Changed()
+}
+
+func (self *Reminds) unpersist(msg *Message) {
+	self.mod.msgIds.Release(msg.Id)
+
+	if err := self.mod.db.Delete(remindsBucket, msg.Id); err != nil {
+		self.mod.Logger.Errorf("Error deleting remind %v: %v\n", msg.Id, err)
+	}
+
+	self.mod.snapshots.Changed()
+}
+
+func ParseMessage(from, to, offset, msg string) (*Message, error) {
 	now := time.Now().UTC()
-	var expire time.Time
-
-	switch duration {
-	case "":
-		expire = now.Add(time.Duration(timeN))
-	case "s", "second", "seconds":
-		expire = now.Add(time.Second * time.Duration(timeN))
-	case "m", "minute", "minutes":
-		expire = now.Add(time.Minute * time.Duration(timeN))
-	case "h", "hour", "hours":
-		expire = now.Add(time.Hour * time.Duration(timeN))
-	case "d", "day", "days":
-		expire = now.Add(time.Hour * 24 * time.Duration(timeN))
-	case "mo", "month", "months": // 1 month == 30 days
-		expire = now.Add(time.Hour * 24 * 30 * time.Duration(timeN))
-	case "y", "year", "years": // 8765.81 hours in a year according to Google
-		expire = now.Add(time.Hour * 8766 * time.Duration(timeN))
-	default:
-		// Module.Logger.Errorf("Error parsing duration: `%v`", duration)
-
-		return nil, fmt.Errorf("Error parsing duration: `%v`", duration)
-	}
-
-	remind := &Message{
+
+	duration, err := ParseDuration(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewMessage(from, to, msg, now, now.Add(duration)), nil
+}
+
+// NewMessage() creates a remind. Its Id is assigned when it is added
+func NewMessage(from, to, msg string, set, expire time.Time) *Message {
+	return &Message{
 		From:    from,
+		To:      to,
 		Message: msg,
 
-		Set:      now,
-		Expire:   expire,
-		duration: time.After(expire.Sub(now)),
+		Set:      set.UTC(),
+		Expire:   expire.UTC(),
+		duration: time.After(expire.Sub(set)),
 	}
-
-	return remind, nil
 }
 
 func (self *Reminds) Add(key ChanNick, msg *Message) {
@@ -146,34 +248,77 @@ func (self *Reminds) Add(key ChanNick, msg *Message) {
 	self.mut.Lock()
 	defer self.mut.Unlock()
 
-	msgList := self.msgMap[key]
+	if msg.Id == "" {
+		msg.Id = self.mod.msgIds.New()
+	} else {
+		self.mod.msgIds.Claim(msg.Id)
+	}
+
+	msgList, ok := self.msgMap[key]
+	if !ok {
+		self.byNick[key.Nick] = append(self.byNick[key.Nick], key)
+	}
 	msgList = append(msgList, msg)
 	self.msgMap[key] = msgList
+
+	self.persist(key, msg)
 }
 
 func (self *Reminds) GetExpired(key ChanNick) []*Message {
+	pending := self.GetExpiredFor(key.Nick, func(k ChanNick, _ *Message) bool {
+		return k == key
+	})
+
+	expiredList := make([]*Message, 0, len(pending))
+	for i := range pending {
+		expiredList = append(expiredList, &pending[i].Message)
+	}
+
+	return expiredList
+}
+
+// GetExpiredFor() pops nick's expired reminds in any channel for which
+// deliver returns true. Recurring reminds are rescheduled instead of removed
+func (self *Reminds) GetExpiredFor(nick string, deliver func(key ChanNick, msg *Message) bool) []Pending {
 	self.mut.Lock()
 	defer self.mut.Unlock()
 
-	expiredList := make([]*Message, 0, 5)
-	expiredInd := make([]int, 0, 5)
+	expiredList := make([]Pending, 0, 5)
+	now := time.Now().UTC()
 
-	msgLst, ok := self.msgMap[key]
-	if !ok {
-		return expiredList
-	}
+	// remove() edits byNick[nick] as keys empty
+	for _, key := range append([]ChanNick{}, self.byNick[nick]...) {
+		msgLst := self.msgMap[key]
+		expiredInd := make([]int, 0, 5)
+
+		for i, rem := range msgLst {
+			if !deliver(key, rem) {
+				continue
+			}
 
-	for i, rem := range msgLst {
-		select {
-		case <-rem.duration:
-			expiredList = append(expiredList, rem)
-			expiredInd = append(expiredInd, i)
-		default:
+			select {
+			case <-rem.duration:
+				// Deliver a copy; missed occurrences of recurring reminds are collapsed
+				expiredList = append(expiredList, Pending{key, *rem})
+
+				if rem.Repeat == nil {
+					expiredInd = append(expiredInd, i)
+
+					continue
+				}
+
+				rem.Set = now
+				rem.Expire = rem.Repeat.Next(now)
+				rem.duration = time.After(rem.Expire.Sub(now))
+				self.persist(key, rem)
+			default:
+			}
 		}
+
+		self.remove(key, expiredInd...)
 	}
 
-	sort.Sort(msgList(expiredList))
-	self.remove(key, expiredInd...)
+	sort.Sort(pendingList(expiredList))
 
 	return expiredList
 }
@@ -197,6 +342,8 @@ func (self *Reminds) String() string {
 			nickList = make([]string, 0, 5)
 		}
 
+		loc := self.mod.timezones.Location(chnNick.Nick)
+
 		for _, msg := range msgList {
 			// Green - Expired
 			// Red   - Active
@@ -206,8 +353,14 @@ func (self *Reminds) String() string {
 				statusColor = styles.Red
 			}
 
-			nickList = append(nickList, fmt.Sprintf("%v %v %v",
-				statusColor.Fg("%v", msg.Expire.Format(pprintFormat)),
+			repeat := ""
+			if msg.Repeat != nil {
+				repeat = fmt.Sprintf(" (%v)", msg.Repeat)
+			}
+
+			nickList = append(nickList, fmt.Sprintf("%v%v %v %v",
+				statusColor.Fg("%v", msg.Expire.In(loc).Format(timeFormat)),
+				repeat,
 				styles.Yellow.Fg("%v", msg.From),
 				msg.Message),
 			)
@@ -230,6 +383,43 @@ func (self *Reminds) String() string {
 	return out
 }
 
+// releaseIds() does not lock. The callee should hold a write lock
+func (self *Reminds) releaseIds() {
+	for _, msgs := range self.msgMap {
+		for _, msg := range msgs {
+			self.mod.msgIds.Release(msg.Id)
+		}
+	}
+}
+
+// reindex() rebuilds byNick from msgMap. It does not lock. The callee should
+// hold a write lock
+func (self *Reminds) reindex() {
+	self.byNick = make(map[string][]ChanNick)
+
+	for key := range self.msgMap {
+		self.byNick[key.Nick] = append(self.byNick[key.Nick], key)
+	}
+}
+
+// unindex() does not lock. The callee should hold a write lock
+func (self *Reminds) unindex(key ChanNick) {
+	keys := self.byNick[key.Nick]
+
+	for i, k := range keys {
+		if k == key {
+			keys = append(keys[:i], keys[i+1:]...)
+			break
+		}
+	}
+
+	if len(keys) == 0 {
+		delete(self.byNick, key.Nick)
+	} else {
+		self.byNick[key.Nick] = keys
+	}
+}
+
 // remove() does not lock. The callee should hold a write lock
 func (self *Reminds) remove(key ChanNick, indices ...int) {
 	msgList, ok := self.msgMap[key]
@@ -246,6 +436,8 @@ func (self *Reminds) remove(key ChanNick, indices ...int) {
 			continue
 		}
 
+		self.unpersist(msgList[i])
+
 		msgList[i], msgList[listLen] = msgList[listLen], msgList[i]
 		msgList = msgList[:listLen]
 		listLen--
@@ -254,6 +446,7 @@ func (self *Reminds) remove(key ChanNick, indices ...int) {
 
 	if len(msgList) == 0 {
 		delete(self.msgMap, key)
+		self.unindex(key)
 	} else {
 		self.msgMap[key] = msgList
 	}
@@ -271,11 +464,16 @@ func (self *Reminds) Copy() map[ChanNick][]Message {
 
 		for _, m := range msgList {
 			msgs = append(msgs, Message{
+				Id:      m.Id,
 				From:    m.From,
+				To:      m.To,
+				Owner:   m.Owner,
 				Message: m.Message,
 
 				Set:      m.Set,
 				Expire:   m.Expire,
+				Repeat:   m.Repeat,
+				Delivery: m.Delivery,
 				duration: nil,
 			})
 		}
@@ -286,6 +484,98 @@ func (self *Reminds) Copy() map[ChanNick][]Message {
 	return rems
 }
 
+// Pending() returns the reminds set by owner, soonest first
+func (self *Reminds) Pending(owner string) []Pending {
+	self.mut.RLock()
+	defer self.mut.RUnlock()
+
+	pending := make([]Pending, 0, 5)
+
+	for key, msgList := range self.msgMap {
+		for _, msg := range msgList {
+			if msg.Owner == owner {
+				pending = append(pending, Pending{key, *msg})
+			}
+		}
+	}
+
+	sort.Sort(pendingList(pending))
+
+	return pending
+}
+
+func (self *Reminds) Cancel(owner, id string) (Pending, bool) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	key, i, ok := self.find(owner, id)
+	if !ok {
+		return Pending{}, false
+	}
+
+	pending := Pending{key, *self.msgMap[key][i]}
+	self.remove(key, i)
+
+	return pending, true
+}
+
+func (self *Reminds) Edit(owner, id, text string) (Pending, bool) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	key, i, ok := self.find(owner, id)
+	if !ok {
+		return Pending{}, false
+	}
+
+	msg := self.msgMap[key][i]
+	msg.Message = text
+	self.persist(key, msg)
+
+	return Pending{key, *msg}, true
+}
+
+// Snooze() pushes a remind back by d from its expiry, or from now if it has
+// already expired
+func (self *Reminds) Snooze(owner, id string, d time.Duration) (Pending, bool) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	key, i, ok := self.find(owner, id)
+	if !ok {
+		return Pending{}, false
+	}
+
+	msg := self.msgMap[key][i]
+	msg.snooze(d)
+	self.persist(key, msg)
+
+	return Pending{key, *msg}, true
+}
+
+// find() does not lock. The callee should hold a lock
+func (self *Reminds) find(owner, id string) (ChanNick, int, bool) {
+	for key, msgList := range self.msgMap {
+		for i, msg := range msgList {
+			if msg.Id == id && msg.Owner == owner {
+				return key, i, true
+			}
+		}
+	}
+
+	return ChanNick{}, 0, false
+}
+
+func (self *Message) snooze(d time.Duration) {
+	now := time.Now().UTC()
+	if self.Expire.Before(now) {
+		self.Expire = now
+	}
+
+	self.Expire = self.Expire.Add(d)
+	self.duration = time.After(self.Expire.Sub(now))
+}
+
 type msgList []*Message
 
 func (self msgList) Len() int {
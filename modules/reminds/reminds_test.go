@@ -0,0 +1,271 @@
+package reminds
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crimsonvoid/ayuko/harness"
+	"github.com/crimsonvoid/ayuko/modules/store"
+)
+
+func startReminds(t *testing.T) (*Module, *harness.Bot) {
+	mod, err := New("", t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mod, harness.StartModule(t, mod.Module)
+}
+
+func TestParseDuration(t *testing.T) {
+	for offset, want := range map[string]time.Duration{
+		"":                       0,
+		"1h30m":                  time.Hour + time.Minute*30,
+		"2 days 4 hours":         time.Hour * 52,
+		"1.5h":                   time.Minute * 90,
+		"1h, 30m and 10s":        time.Hour + time.Minute*30 + time.Second*10,
+		"1 minute, and 1 second": time.Minute + time.Second,
+	} {
+		if got, err := ParseDuration(offset); err != nil || got != want {
+			t.Errorf("ParseDuration(%q) = %v, %v; want %v", offset, got, err, want)
+		}
+	}
+
+	for _, offset := range []string{"1h foo 30m", "soon", "about 1h", "1h later", "1h or 2h"} {
+		if got, err := ParseDuration(offset); err == nil {
+			t.Errorf("ParseDuration(%q) = %v; want an error", offset, got)
+		}
+	}
+}
+
+func TestRemindDelivered(t *testing.T) {
+	mod, bot := startReminds(t)
+
+	msgs, err := bot.Say("alice", harness.Channel, "-remind bob in 1 hour to water the plants", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || !strings.HasPrefix(msgs[0].Text, "Okay I'll remind bob about that in 1h") {
+		t.Fatalf("got %v; want the remind confirmed", msgs)
+	}
+
+	if msgs, _ := bot.Say("bob", harness.Channel, "hi", 0); len(msgs) != 0 {
+		t.Fatalf("before it expired: got %v; want no reply", msgs)
+	}
+
+	due(mod)
+
+	msgs, err = bot.Say("bob", harness.Channel, "hi again", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Oh bob! alice wanted me to remind you to water the plants"
+	if len(msgs) != 1 || msgs[0].Target != harness.Channel || msgs[0].Text != want {
+		t.Fatalf("got %v; want %q", msgs, want)
+	}
+
+	if msgs, _ := bot.Say("bob", harness.Channel, "and again", 0); len(msgs) != 0 {
+		t.Errorf("after delivery: got %v; want no reply", msgs)
+	}
+}
+
+func TestRemindsListAndCancel(t *testing.T) {
+	mod, bot := startReminds(t)
+
+	if _, err := bot.Say("alice", harness.Channel, "-remind bob in 1 hour about lunch", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	pending := mod.reminds.Pending("alice")
+	if len(pending) != 1 {
+		t.Fatalf("Pending() = %v; want one remind", pending)
+	}
+	id := pending[0].Id
+
+	msgs, err := bot.Say("alice", harness.Channel, "-reminds", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Cmd != "NOTICE" || !strings.Contains(msgs[0].Text, "["+id+"]") {
+		t.Fatalf("-reminds: got %v; want a notice listing %v", msgs, id)
+	}
+
+	if _, err := bot.Say("alice", harness.Channel, "-remind cancel "+id, 1); err != nil {
+		t.Fatal(err)
+	}
+	if pending := mod.reminds.Pending("alice"); len(pending) != 0 {
+		t.Errorf("after cancel: Pending() = %v; want none", pending)
+	}
+}
+
+func TestAlert(t *testing.T) {
+	_, bot := startReminds(t)
+
+	msgs, err := bot.Say("alice", harness.Channel, "-alert me in 1 second stretch", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || !strings.HasPrefix(msgs[0].Text, "Okay I'll alert you about that in 1s") {
+		t.Fatalf("got %v; want the alert confirmed", msgs)
+	}
+
+	msg, err := bot.Server.Expect(harness.ReplyTimeout, harness.To(harness.Channel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "alice: You wanted me to remind you stretch"; msg.Text != want {
+		t.Errorf("got %q; want %q", msg.Text, want)
+	}
+}
+
+func TestRecurringLimit(t *testing.T) {
+	mod, bot := startReminds(t)
+
+	mod.maxRecurring = 1
+
+	if _, err := bot.Say("alice", harness.Channel, "-remind bob every 1 hour to stretch", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, err := bot.Say("alice", harness.Channel, "-remind bob every 2 hours to drink water", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "I'm sorry, you can only have 1 recurring reminds. " +
+		"Use -reminds and -remind cancel <id> to remove some"
+	if len(msgs) != 1 || msgs[0].Cmd != "NOTICE" || msgs[0].Text != want {
+		t.Errorf("got %v; want the notice %q", msgs, want)
+	}
+}
+
+// due() makes every pending remind due now, rather than waiting for it
+func due(mod *Module) {
+	mod.reminds.mut.Lock()
+	defer mod.reminds.mut.Unlock()
+
+	for _, msgs := range mod.reminds.msgMap {
+		for _, msg := range msgs {
+			msg.duration = time.After(0)
+		}
+	}
+}
+
+// waitFor() polls cond, since raw events are handled on their own goroutines
+func waitFor(t *testing.T, what string, cond func() bool) {
+	for deadline := time.Now().Add(time.Second * 3); !cond(); time.Sleep(time.Millisecond * 10) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+	}
+}
+
+func TestPresence(t *testing.T) {
+	online := newPresence(nil)
+
+	online.Join("#a", "Bob")
+	online.Join("#b", "bob")
+	online.Join("#b", "carol")
+
+	online.Part("#a", "bob")
+	if !online.Online("BOB") {
+		t.Error("bob left #a but is still in #b")
+	}
+
+	online.Rename("bob", "robert")
+	if online.Online("bob") || !online.Online("robert") {
+		t.Error("Rename() did not follow bob to robert")
+	}
+
+	online.Leave("#b")
+	if online.Online("robert") || online.Online("carol") {
+		t.Errorf("after leaving #b: Nicks() = %v; want none", online.Nicks())
+	}
+
+	online.Join("#a", "dave")
+	online.Quit("dave")
+	if nicks := online.Nicks(); len(nicks) != 0 {
+		t.Errorf("after QUIT: Nicks() = %v; want none", nicks)
+	}
+}
+
+func TestPrivateRemindKeptAfterPart(t *testing.T) {
+	mod, bot := startReminds(t)
+
+	bot.Server.Join("bob", harness.Channel)
+	waitFor(t, "bob to join", func() bool { return mod.online.Online("bob") })
+	bot.Server.Part("bob", harness.Channel, "bye")
+	waitFor(t, "bob to part", func() bool { return !mod.online.Online("bob") })
+
+	now := time.Now()
+	msg := NewMessage("alice", "bob", "to call back", now.Add(-time.Minute), now.Add(-time.Second))
+	msg.Owner, msg.Delivery = "alice", deliverPM
+	msg.duration = time.After(0) // Already due
+	mod.reminds.Add(ChanNick{strings.ToLower(harness.Channel), "bob"}, msg)
+
+	mod.deliverPrivate("bob")
+
+	if msgs := bot.Quiet(); len(msgs) != 0 {
+		t.Errorf("bob is offline: got %v; want no reply", msgs)
+	}
+	if pending := mod.reminds.Pending("alice"); len(pending) != 1 {
+		t.Fatalf("Pending() = %v; want the remind kept for bob", pending)
+	}
+
+	bot.Server.Join("bob", harness.Channel)
+
+	msg2, err := bot.Server.Expect(harness.ReplyTimeout, harness.To("bob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(msg2.Text, "alice wanted me to remind you to call back") {
+		t.Errorf("got %q; want the remind", msg2.Text)
+	}
+}
+
+func TestConsoleBeforeConnect(t *testing.T) {
+	mod, err := New("", t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mod.exportReminds("reminds.json"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mod.importReminds("reminds.json", store.Merge, false); err != store.ErrClosed {
+		t.Errorf("importReminds() before connecting = %v; want %v", err, store.ErrClosed)
+	}
+	if _, err := mod.importReminds("reminds.json", store.Merge, true); err != nil {
+		t.Errorf("importReminds() dry run before connecting = %v; want no error", err)
+	}
+}
+
+func TestDryRunImportKeepsIds(t *testing.T) {
+	dir := t.TempDir()
+
+	mod, err := New("", dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	export := `{"version": 1, "reminds": [
+		{"kind": "remind", "channel": "#test", "to": "bob", "from": "alice", "message": "one", "expire": "2030-01-01T00:00:00Z"},
+		{"kind": "remind", "channel": "#test", "to": "bob", "from": "alice", "message": "two", "expire": "2030-01-01T00:00:00Z"}
+	]}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "reminds.json"), []byte(export), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := mod.importReminds("reminds.json", store.Merge, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Added != 2 {
+		t.Errorf("dry run report = %v; want 2 added", report)
+	}
+	if used := len(mod.msgIds.used); used != 0 {
+		t.Errorf("dry run left %v ids in use; want 0", used)
+	}
+}
@@ -0,0 +1,77 @@
+package reminds
+
+import (
+	"encoding/gob"
+	"os"
+
+	"github.com/crimsonvoid/ayuko/modules/store"
+)
+
+// snapshotFile is the layout of a snapshot. Snapshots before version 3 are a
+// remindsFile and only hold reminds
+type snapshotFile struct {
+	Version    int
+	Reminds    map[ChanNick][]*Message
+	Alerts     map[string][]*Message
+	Timezones  map[string]string // map[nick]IANA zone name
+	Deliveries map[string]string // map[nick]delivery policy
+}
+
+// saveSnapshot() writes every remind, alert, timezone and delivery policy to
+// fileName
+func (self *Module) saveSnapshot(fileName string) error {
+	reminds := make(map[ChanNick][]*Message)
+	for key, msgs := range self.reminds.Copy() {
+		for i := range msgs {
+			reminds[key] = append(reminds[key], &msgs[i])
+		}
+	}
+
+	return store.WriteGob(self.dataDir+fileName, snapshotFile{
+		Version:    snapshotVersion,
+		Reminds:    reminds,
+		Alerts:     self.alerts.Copy(),
+		Timezones:  self.timezones.copy(),
+		Deliveries: self.deliveries.copy(),
+	})
+}
+
+// loadSnapshot() replaces everything with the snapshot in fileName. Older
+// snapshots only replace reminds
+func (self *Module) loadSnapshot(fileName string) error {
+	if self.db == nil {
+		return store.ErrClosed
+	}
+
+	file, err := os.Open(self.dataDir + fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	snap := snapshotFile{}
+
+	snapDec := gob.NewDecoder(file)
+	if err := snapDec.Decode(&snap); err != nil {
+		return err
+	}
+
+	if snap.Version < snapshotVersion {
+		return self.reminds.replace(remindsFile{snap.Version, snap.Reminds})
+	}
+
+	// Clearing reminds first frees their ids for the restored alerts
+	for _, restore := range []func() error{
+		self.reminds.Clear,
+		func() error { return self.alerts.Restore(snap.Alerts) },
+		func() error { return self.reminds.replace(remindsFile{remindsVersion, snap.Reminds}) },
+		func() error { return self.timezones.replace(snap.Timezones) },
+		func() error { return self.deliveries.replace(snap.Deliveries) },
+	} {
+		if err := restore(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package reminds
+
+import (
+	"time"
+)
+
+type Timezones struct {
+	nickPrefs // map[nick]IANA zone name
+}
+
+func NewTimezones(mod *Module) *Timezones {
+	return &Timezones{newNickPrefs(mod, "timezones.gob")}
+}
+
+// Set() validates zone and saves it for nick, returning the loaded location
+func (self *Timezones) Set(nick, zone string) (*time.Location, error) {
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, err
+	}
+
+	self.set(nick, loc.String())
+
+	return loc, nil
+}
+
+// Location() returns nick's timezone, or the configured default if unset
+func (self *Timezones) Location(nick string) *time.Location {
+	zone, ok := self.get(nick)
+	if !ok {
+		return self.mod.defaultLoc
+	}
+
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return self.mod.defaultLoc
+	}
+
+	return loc
+}
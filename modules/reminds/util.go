@@ -2,7 +2,11 @@ package reminds
 
 import (
 	"fmt"
+	"math/rand"
 	"regexp"
+	"strings"
+	"sync"
+	"time"
 )
 
 func matchGroups(reg *regexp.Regexp, s string) (map[string]string, error) {
@@ -21,3 +25,80 @@ func matchGroups(reg *regexp.Regexp, s string) (map[string]string, error) {
 
 	return groups, nil
 }
+
+// isMe() reports whether nick is the bot's own
+func (self *Module) isMe(nick string) bool {
+	return self.Conn != nil && strings.EqualFold(nick, self.Conn.Me().Nick)
+}
+
+func isChannel(target string) bool {
+	return strings.HasPrefix(target, "#") || strings.HasPrefix(target, "&")
+}
+
+// idSource hands out short random Message ids that no remind or alert is
+// using. Reminds and alerts are stored by id, so a reused id would overwrite
+// someone else's
+type idSource struct {
+	rng  *rand.Rand
+	used map[string]bool
+	mut  sync.Mutex
+}
+
+func newIdSource() *idSource {
+	return &idSource{
+		rng:  rand.New(rand.NewSource(time.Now().UnixNano())),
+		used: make(map[string]bool),
+	}
+}
+
+// New() returns an unused id and marks it used
+func (self *idSource) New() string {
+	const chars = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	id := make([]byte, idLen)
+
+	for {
+		for i := range id {
+			id[i] = chars[self.rng.Intn(len(chars))]
+		}
+
+		if !self.used[string(id)] {
+			self.used[string(id)] = true
+
+			return string(id)
+		}
+	}
+}
+
+// Claim() marks id used, reporting false if it already was
+func (self *idSource) Claim(id string) bool {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	if self.used[id] {
+		return false
+	}
+	self.used[id] = true
+
+	return true
+}
+
+func (self *idSource) Release(ids ...string) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	for _, id := range ids {
+		delete(self.used, id)
+	}
+}
+
+// Reset() releases every id
+func (self *idSource) Reset() {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	self.used = make(map[string]bool)
+}
@@ -3,43 +3,105 @@ package reminds
 import (
 	"fmt"
 	"regexp"
+	"time"
 
+	"github.com/crimsonvoid/ayuko/modules/identity"
+	"github.com/crimsonvoid/ayuko/modules/raw"
+	"github.com/crimsonvoid/ayuko/modules/store"
 	"github.com/crimsonvoid/irclib/module"
 )
 
 const (
-	dataDir = "./data/reminds/"
+	defaultDataDir = "./data/reminds/"
+	dbName         = "reminds.db"
 
-	timeFormat   = "02 Jan 2006 15:04 MST"
-	pprintFormat = "02 Jan 2006 15:04"
+	remindsBucket = "reminds"
+	alertsBucket  = "alerts"
 
-	nickR     = `[\w{}\[\]^|` + "`" + `-]+`
-	idsR      = `(?P<ids>(` + nickR + `( and |,( and)? )?)+)`
-	timeR     = `(?P<time>\d+)`
-	durationR = `(?P<duration>` +
-		`s(econd(s)?)?|` +
-		`m(inute(s)?)?|` +
+	timeFormat = "02 Jan 2006 15:04 MST"
+
+	remindsVersion  = 2 // remindsFile layout version
+	snapshotVersion = 3 // snapshotFile layout version
+	idLen           = 5 // Length of Message.Id
+
+	minRecurrence       = time.Minute * 5 // Shortest "every" interval
+	defaultMaxRecurring = 5               // Recurring reminds one nick may own
+
+	defaultAutosave = time.Hour   // Snapshot interval
+	defaultDebounce = time.Minute // Snapshot delay after a change
+
+	pmInterval = time.Minute        // How often private reminds are checked
+	alertGrace = time.Minute        // Delay for alerts that expired while offline
+	alertIdle  = time.Hour * 24 * 7 // Scheduler wakeup when no alerts are pending
+
+	namesPrefixes = "~&@%+" // Channel modes prefixed to nicks in NAMES
+
+	nickR = `[\w{}\[\]^|` + "`" + `-]+`
+	chanR = `[#&][^\s,]+`
+	idsR  = `(?P<ids>((` + chanR + `|` + nickR + `)( and |,( and)? )?)+)`
+	numR  = `\d+(\.\d+)?`
+	unitR = `(` +
+		`s(ec(ond)?(s)?)?|` +
+		`m(in(ute)?(s)?)?|` +
 		`h(our(s)?)?|` +
 		`d(ay(s)?)?|` +
 		`w(eek(s)?)?|` +
 		`mo(nth(s)?)?|` +
 		`y(ear(s)?)?` +
 		`)`
+	intervalR = `(` + numR + ` ?` + unitR + `(, ?| and | )?)+`
+	offsetR   = `(?P<offset>` + intervalR + `)`
+
+	clockR   = `\d{1,2}(:\d{2})? ?([ap]m)?`
+	dateR    = `\d{4}-\d{2}-\d{2}`
+	weekdayR = `mon(day)?|tue(s(day)?)?|wed(nesday)?|thu(rs(day)?)?|fri(day)?|sat(urday)?|sun(day)?`
+	dayR     = `(on )?(` + dateR + `|(next )?(` + weekdayR + `))|today|tomorrow|next week`
+	whenR    = `(?P<when>(` + dayR + `)( at ` + clockR + `)?|at ` + clockR + `( (` + dayR + `))?)`
+	viaR     = `via (?P<via>channel|join|anywhere|pm)`
+	everyR   = `every ((?P<interval>` + intervalR + `)|(?P<days>(day|weekday|` + weekdayR + `)( at ` + clockR + `)?))`
 )
 
 var (
-	remindsR = regexp.MustCompile(fmt.Sprintf("(?i)^-remind %v (in )?(%v ?%v )?(that )?%v$",
-		idsR, timeR, durationR, `(?P<message>.*)`),
+	remindsR = regexp.MustCompile(fmt.Sprintf("(?i)^-remind %v ((in )?%v |%v |%v )?(%v )?(that )?%v$",
+		idsR, offsetR, whenR, everyR, viaR, `(?P<message>.*)`),
 	)
 
-	alertsR = regexp.MustCompile(fmt.Sprintf("(?i)^-(hi(gh)?light|alert) %v (in )?(%v ?%v )?(that )?%v$",
-		nickR, timeR, durationR, `(?P<message>.*)`),
+	clockRe   = regexp.MustCompile(`(?i)at (?P<hour>\d{1,2})(:(?P<minute>\d{2}))? ?(?P<meridiem>[ap]m)?`)
+	dateRe    = regexp.MustCompile(`(?P<date>` + dateR + `)`)
+	pairRe    = regexp.MustCompile(`(?i)(?P<num>` + numR + `) ?(?P<unit>[a-z]+)`)
+	pairSepRe = regexp.MustCompile(`^\s*(,|and|, ?and)?\s*$`)
+	weekdayRe = regexp.MustCompile(`(?i)\b(?P<next>next )?(?P<weekday>` + weekdayR + `)\b`)
+
+	pendingR = regexp.MustCompile(`(?i)^-reminds\s*$`)
+	manageR  = regexp.MustCompile(`(?i)^-remind (?P<action>cancel|edit|snooze) (?P<id>[a-z0-9]+)( (?P<arg>.*))?$`)
+
+	deliveryR = regexp.MustCompile(`(?i)^-delivery( set (?P<policy>channel|join|anywhere|pm)| (?P<unset>unset))?\s*$`)
+
+	tzR = regexp.MustCompile(`(?i)^-tz( set (?P<zone>\S+)| (?P<unset>unset))?\s*$`)
+
+	alertsR = regexp.MustCompile(fmt.Sprintf("(?i)^-(hi(gh)?light|alert) (?P<nick>%v) ((in )?%v )?(that )?%v$",
+		nickR, offsetR, `(?P<message>.*)`),
 	)
 )
 
-var (
-	reminds = NewReminds()
-	alerts  = newAlerts()
+// Module is one instance of the reminds module
+type Module struct {
+	*module.Module
 
-	Module *module.Module
-)
+	ids     *identity.Module // Resolves nicks; nil treats every nick as its own identity
+	dataDir string
+
+	msgIds     *idSource // Ids of every remind and alert
+	reminds    *Reminds
+	alerts     *Alerts
+	timezones  *Timezones
+	deliveries *Deliveries
+	online     *presence
+	events     raw.Handlers // JOIN, PART, KICK, NICK, QUIT and NAMES
+
+	db        *store.Store // Open between Preconnect and Disconnect
+	snapshots *store.Snapshots
+
+	defaultLoc   *time.Location
+	maxRecurring int
+}
@@ -0,0 +1,121 @@
+package reminds
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseWhen resolves an absolute time phrase matched by whenR ("at 18:30",
+// "on 2026-12-24", "tomorrow at 9am", "next friday", ...) against now. The
+// result is in now's location. Phrases without a clock time keep the current
+// time of day, and a bare clock time that has already passed rolls over to
+// the next day.
+func ParseWhen(when string, now time.Time) (time.Time, error) {
+	when = strings.ToLower(strings.TrimSpace(when))
+	loc := now.Location()
+
+	year, month, day := now.Date()
+	hour, minute := now.Hour(), now.Minute()
+
+	// Days to add when the resolved time has already passed today
+	rollover := 0
+
+	switch {
+	case dateRe.MatchString(when):
+		groups, _ := matchGroups(dateRe, when)
+
+		date, err := time.ParseInLocation("2006-01-02", groups["date"], loc)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("Error parsing date: `%v`", groups["date"])
+		}
+		year, month, day = date.Date()
+	case strings.Contains(when, "tomorrow"):
+		day++
+	case strings.Contains(when, "next week"):
+		day += 7
+	case weekdayRe.MatchString(when):
+		groups, _ := matchGroups(weekdayRe, when)
+
+		wd := weekdays[groups["weekday"][:3]]
+		days := (int(wd) - int(now.Weekday()) + 7) % 7
+		if days == 0 {
+			if groups["next"] != "" || !clockRe.MatchString(when) {
+				days = 7
+			} else {
+				rollover = 7
+			}
+		}
+		day += days
+	case strings.Contains(when, "today"):
+	default:
+		rollover = 1
+	}
+
+	if clockRe.MatchString(when) {
+		groups, _ := matchGroups(clockRe, when)
+
+		var err error
+		if hour, minute, err = parseClock(groups); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	expire := time.Date(year, month, day, hour, minute, 0, 0, loc)
+
+	// "at 9am" after 9am means tomorrow, "sunday at 9am" means next sunday
+	if !expire.After(now) {
+		expire = expire.AddDate(0, 0, rollover)
+	}
+
+	if !expire.After(now) {
+		return time.Time{}, fmt.Errorf("`%v` (%v) has already passed",
+			when, expire.Format(timeFormat))
+	}
+
+	return expire, nil
+}
+
+func parseClock(groups map[string]string) (int, int, error) {
+	hour, err := strconv.Atoi(groups["hour"])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	minute := 0
+	if groups["minute"] != "" {
+		if minute, err = strconv.Atoi(groups["minute"]); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	switch strings.ToLower(groups["meridiem"]) {
+	case "am":
+		if hour < 1 || hour > 12 {
+			return 0, 0, fmt.Errorf("Invalid hour: `%v`", hour)
+		}
+		hour %= 12
+	case "pm":
+		if hour < 1 || hour > 12 {
+			return 0, 0, fmt.Errorf("Invalid hour: `%v`", hour)
+		}
+		hour = hour%12 + 12
+	}
+
+	if hour > 23 || minute > 59 {
+		return 0, 0, fmt.Errorf("Invalid time: `%02d:%02d`", hour, minute)
+	}
+
+	return hour, minute, nil
+}
@@ -8,17 +8,17 @@ import (
 	irc "github.com/fluffle/goirc/client"
 )
 
-func registerCommands() {
-	regCommandRoll()
+func (self *Module) registerCommands() {
+	self.regCommandRoll()
 }
 
-func regCommandRoll() {
+func (self *Module) regCommandRoll() {
 	re := regexp.MustCompile(`^(-|\.)roll .*`)
 
-	Module.Register(module.E_PRIVMSG, re, func(line *irc.Line) {
-		num := rng.Intn(101)
+	self.Register(module.E_PRIVMSG, re, func(line *irc.Line) {
+		num := self.rng.Intn(101)
 
-		Module.Conn.Privmsg(
+		self.Conn.Privmsg(
 			line.Target(),
 			fmt.Sprintf("%v: %v%%", line.Nick, num))
 	})
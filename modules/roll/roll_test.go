@@ -0,0 +1,42 @@
+package roll
+
+import (
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/crimsonvoid/ayuko/harness"
+)
+
+func TestRoll(t *testing.T) {
+	mod, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bot := harness.StartModule(t, mod.Module)
+
+	replyRe := regexp.MustCompile(`^alice: (\d+)%$`)
+
+	for _, text := range []string{"-roll will it rain?", ".roll pass the exam"} {
+		msgs, err := bot.Say("alice", harness.Channel, text, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != 1 || msgs[0].Target != harness.Channel {
+			t.Fatalf("%q: got %v; want one reply in %v", text, msgs, harness.Channel)
+		}
+
+		res := replyRe.FindStringSubmatch(msgs[0].Text)
+		if res == nil {
+			t.Fatalf("%q: got %q; want alice: N%%", text, msgs[0].Text)
+		}
+		if n, _ := strconv.Atoi(res[1]); n < 0 || n > 100 {
+			t.Errorf("%q: rolled %v; want 0 to 100", text, n)
+		}
+	}
+
+	if msgs, _ := bot.Say("alice", harness.Channel, "-roll", 0); len(msgs) != 0 {
+		t.Errorf("-roll without a question: got %v; want no reply", msgs)
+	}
+}
@@ -2,12 +2,13 @@ package roll
 
 import (
 	"math/rand"
-	"time"
 
 	"github.com/crimsonvoid/irclib/module"
 )
 
-var (
-	rng    = rand.New(rand.NewSource(time.Now().UnixNano()))
-	Module *module.Module
-)
+// Module is one instance of the roll module
+type Module struct {
+	*module.Module
+
+	rng *rand.Rand
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"encoding/gob"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WriteFile atomically replaces fileName with whatever write produces. The
+// data is written to a temporary file in the same directory, synced, and
+// renamed over fileName so readers never see a partial file
+func WriteFile(fileName string, write func(w io.Writer) error) error {
+	dir := filepath.Dir(fileName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(fileName)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	if err = write(tmp); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, fileName)
+	}
+
+	if err != nil {
+		os.Remove(tmpName)
+	}
+
+	return err
+}
+
+// WriteGob atomically writes value gob encoded to fileName
+func WriteGob(fileName string, value interface{}) error {
+	return WriteFile(fileName, func(w io.Writer) error {
+		return gob.NewEncoder(w).Encode(value)
+	})
+}
+
+// DataDir returns dir, or def if dir is empty, ending in a slash so file
+// names can be appended to it
+func DataDir(dir, def string) string {
+	if dir == "" {
+		dir = def
+	}
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+
+	return dir
+}
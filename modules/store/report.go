@@ -0,0 +1,49 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Import modes
+const (
+	Merge   = "merge"   // Keep existing records, imported ones win conflicts
+	Replace = "replace" // Drop every existing record first
+)
+
+// ImportReport summarises an import, or what an import would do in a dry run
+type ImportReport struct {
+	Mode   string
+	DryRun bool
+
+	Added, Updated, Unchanged, Removed int
+
+	Conflicts []string // Existing records that differ from the imported ones
+	Invalid   []string // Records that failed validation and were skipped
+}
+
+func (self *ImportReport) Conflict(format string, v ...interface{}) {
+	self.Conflicts = append(self.Conflicts, fmt.Sprintf(format, v...))
+}
+
+func (self *ImportReport) Reject(format string, v ...interface{}) {
+	self.Invalid = append(self.Invalid, fmt.Sprintf(format, v...))
+}
+
+func (self *ImportReport) String() string {
+	out := fmt.Sprintf("%v: %v added, %v updated, %v unchanged, %v removed, %v invalid",
+		self.Mode, self.Added, self.Updated, self.Unchanged, self.Removed, len(self.Invalid))
+	if self.DryRun {
+		out = "(dry run) " + out
+	}
+
+	lines := []string{out}
+	for _, conflict := range self.Conflicts {
+		lines = append(lines, "  conflict: "+conflict)
+	}
+	for _, invalid := range self.Invalid {
+		lines = append(lines, "  invalid: "+invalid)
+	}
+
+	return strings.Join(lines, "\n")
+}
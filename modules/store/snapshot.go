@@ -0,0 +1,325 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Layout of snapshot names relative to Snapshots.Dir,
+// eg "2026-10 October/18_(13.05).gob"
+const snapshotLayout = "2006-01 January/02_(15.04).gob"
+
+// Retention is how many of the newest hourly, daily and monthly snapshots
+// to keep. A snapshot is kept if it is the newest of its hour, day or month
+// and that hour, day or month is within the newest Hourly, Daily or Monthly.
+// A count of 0 keeps none of that period, though the newest snapshot is
+// always kept. All keeps every snapshot, ignoring the counts
+type Retention struct {
+	Hourly, Daily, Monthly int
+	All                    bool
+}
+
+// Snapshots periodically writes timestamped snapshots through Save and
+// prunes old ones according to Keep
+type Snapshots struct {
+	Dir  string                                // Directory snapshots are written to
+	Save func(fileName string) error           // Writes a snapshot to Dir+fileName
+	Load func(fileName string) error           // Restores the snapshot at Dir+fileName
+	Log  func(format string, v ...interface{}) // Reports autosave errors
+
+	Interval time.Duration // Autosave interval; 0 disables autosaving
+	Debounce time.Duration // Delay after a change before saving; 0 disables
+	Keep     Retention
+
+	dirty    bool
+	dirtyMut sync.Mutex
+	changed  chan bool
+	quit     chan bool
+	done     chan bool
+	mut      sync.Mutex // Held while saving or restoring
+}
+
+// SnapshotConfig is the toml configuration of a module's Snapshots. Keep
+// counts are pointers so 0, keeping none of that period, can be told apart
+// from unset
+type SnapshotConfig struct {
+	Autosave    string `toml:"autosave"` // Autosave interval, eg "1h"
+	Debounce    string `toml:"debounce"` // Delay after a change before saving, eg "1m"
+	KeepHourly  *int   `toml:"keep_hourly"`
+	KeepDaily   *int   `toml:"keep_daily"`
+	KeepMonthly *int   `toml:"keep_monthly"`
+	KeepAll     bool   `toml:"keep_all"` // Never prune; the counts are ignored
+}
+
+// Apply() overrides the settings of snaps that are set in self
+func (self *SnapshotConfig) Apply(snaps *Snapshots) error {
+	if self.Autosave != "" {
+		d, err := time.ParseDuration(self.Autosave)
+		if err != nil {
+			return err
+		}
+		snaps.Interval = d
+	}
+	if self.Debounce != "" {
+		d, err := time.ParseDuration(self.Debounce)
+		if err != nil {
+			return err
+		}
+		snaps.Debounce = d
+	}
+
+	for _, keep := range []struct {
+		conf *int
+		keep *int
+	}{
+		{self.KeepHourly, &snaps.Keep.Hourly},
+		{self.KeepDaily, &snaps.Keep.Daily},
+		{self.KeepMonthly, &snaps.Keep.Monthly},
+	} {
+		if keep.conf == nil {
+			continue
+		}
+		if *keep.conf < 0 {
+			return fmt.Errorf("keep counts can not be negative: %v", *keep.conf)
+		}
+
+		*keep.keep = *keep.conf
+	}
+
+	if self.KeepAll {
+		snaps.Keep.All = true
+	}
+
+	return nil
+}
+
+// Snapshot is a snapshot on disk
+type Snapshot struct {
+	Name string // Relative to Snapshots.Dir, as passed to Load
+	Time time.Time
+}
+
+// Start() begins autosaving in the background
+func (self *Snapshots) Start() error {
+	self.changed = make(chan bool, 1)
+	self.quit = make(chan bool)
+	self.done = make(chan bool)
+
+	go self.run()
+
+	return nil
+}
+
+// Exit() stops autosaving and writes a final snapshot if anything changed
+// since the last one
+func (self *Snapshots) Exit() error {
+	if self.quit != nil {
+		close(self.quit)
+		<-self.done
+		self.quit = nil
+	}
+
+	if !self.isDirty() {
+		return nil
+	}
+
+	return self.Snapshot()
+}
+
+// Changed() marks the data as modified, saving it once Debounce passes
+// without further changes or at the next Interval, whichever comes first
+func (self *Snapshots) Changed() {
+	self.setDirty(true)
+
+	select {
+	case self.changed <- true:
+	default:
+	}
+}
+
+// Snapshot() writes a snapshot now and prunes old ones
+func (self *Snapshots) Snapshot() error {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	// Changes made while saving mark the data dirty again
+	self.setDirty(false)
+
+	now := time.Now().UTC()
+	if err := self.Save(now.Format(snapshotLayout)); err != nil {
+		self.setDirty(true)
+
+		return err
+	}
+
+	return self.prune()
+}
+
+// List() returns every snapshot, newest first
+func (self *Snapshots) List() ([]Snapshot, error) {
+	paths, err := filepath.Glob(filepath.Join(self.Dir, "*", "*.gob"))
+	if err != nil {
+		return nil, err
+	}
+
+	snaps := make([]Snapshot, 0, len(paths))
+	for _, path := range paths {
+		name, err := filepath.Rel(self.Dir, path)
+		if err != nil {
+			continue
+		}
+		name = filepath.ToSlash(name)
+
+		t, err := time.Parse(snapshotLayout, name)
+		if err != nil {
+			continue // Not a snapshot
+		}
+
+		snaps = append(snaps, Snapshot{name, t})
+	}
+
+	sort.Sort(snapshotList(snaps))
+
+	return snaps, nil
+}
+
+// Restore() loads the snapshot name, as returned by List()
+func (self *Snapshots) Restore(name string) error {
+	if _, err := time.Parse(snapshotLayout, name); err != nil {
+		return err
+	}
+
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	return self.Load(name)
+}
+
+func (self *Snapshots) run() {
+	defer close(self.done)
+
+	var (
+		tick     <-chan time.Time
+		debounce <-chan time.Time
+	)
+
+	if self.Interval > 0 {
+		ticker := time.NewTicker(self.Interval)
+		defer ticker.Stop()
+
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-self.changed:
+			if self.Debounce > 0 {
+				debounce = time.After(self.Debounce)
+			}
+			continue
+		case <-debounce:
+		case <-tick:
+		case <-self.quit:
+			return
+		}
+
+		debounce = nil
+
+		if !self.isDirty() {
+			continue
+		}
+
+		if err := self.Snapshot(); err != nil && self.Log != nil {
+			self.Log("Error writing snapshot: %v\n", err)
+		}
+	}
+}
+
+func (self *Snapshots) setDirty(dirty bool) {
+	self.dirtyMut.Lock()
+	defer self.dirtyMut.Unlock()
+
+	self.dirty = dirty
+}
+
+func (self *Snapshots) isDirty() bool {
+	self.dirtyMut.Lock()
+	defer self.dirtyMut.Unlock()
+
+	return self.dirty
+}
+
+// prune() does not lock. The callee should hold a lock
+func (self *Snapshots) prune() error {
+	if self.Keep.All {
+		return nil
+	}
+
+	snaps, err := self.List()
+	if err != nil {
+		return err
+	}
+
+	keep := make(map[string]bool, len(snaps))
+	if len(snaps) > 0 {
+		keep[snaps[0].Name] = true
+	}
+
+	for _, period := range []struct {
+		layout string
+		count  int
+	}{
+		{"2006-01-02 15", self.Keep.Hourly},
+		{"2006-01-02", self.Keep.Daily},
+		{"2006-01", self.Keep.Monthly},
+	} {
+		seen := make(map[string]bool, period.count)
+
+		for _, snap := range snaps {
+			key := snap.Time.Format(period.layout)
+			if seen[key] {
+				continue
+			}
+			if len(seen) >= period.count {
+				break
+			}
+
+			seen[key] = true
+			keep[snap.Name] = true
+		}
+	}
+
+	for _, snap := range snaps {
+		if keep[snap.Name] {
+			continue
+		}
+
+		path := filepath.Join(self.Dir, filepath.FromSlash(snap.Name))
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+
+		// Remove the month directory once it is empty; fails otherwise
+		os.Remove(filepath.Dir(path))
+	}
+
+	return nil
+}
+
+type snapshotList []Snapshot
+
+func (self snapshotList) Len() int {
+	return len(self)
+}
+
+func (self snapshotList) Less(i, j int) bool {
+	return self[i].Time.After(self[j].Time)
+}
+
+func (self snapshotList) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
@@ -0,0 +1,107 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testSnapshots() returns Snapshots in a new directory holding one old
+// snapshot for each of the last n days
+func testSnapshots(t *testing.T, n int) *Snapshots {
+	self := &Snapshots{Dir: t.TempDir()}
+	self.Save = func(fileName string) error {
+		path := filepath.Join(self.Dir, filepath.FromSlash(fileName))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+
+		return ioutil.WriteFile(path, nil, 0644)
+	}
+
+	now := time.Now().UTC()
+	for i := 1; i <= n; i++ {
+		if err := self.Save(now.AddDate(0, 0, -i).Format(snapshotLayout)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return self
+}
+
+func countSnapshots(t *testing.T, snaps *Snapshots) int {
+	list, err := snaps.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return len(list)
+}
+
+func TestPruneZeroKeepsNewest(t *testing.T) {
+	snaps := testSnapshots(t, 3)
+
+	if err := snaps.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countSnapshots(t, snaps); n != 1 {
+		t.Errorf("zero Retention kept %v snapshots; want only the newest", n)
+	}
+}
+
+func TestPruneDaily(t *testing.T) {
+	snaps := testSnapshots(t, 5)
+	snaps.Keep = Retention{Daily: 3}
+
+	if err := snaps.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countSnapshots(t, snaps); n != 3 {
+		t.Errorf("Daily: 3 kept %v snapshots; want 3", n)
+	}
+}
+
+func TestPruneAll(t *testing.T) {
+	snaps := testSnapshots(t, 3)
+
+	zero := 0
+	conf := SnapshotConfig{KeepHourly: &zero, KeepDaily: &zero, KeepMonthly: &zero, KeepAll: true}
+	if err := conf.Apply(snaps); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := snaps.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countSnapshots(t, snaps); n != 4 {
+		t.Errorf("keep_all kept %v snapshots; want all 4", n)
+	}
+}
+
+func TestExitOnlyWhenDirty(t *testing.T) {
+	snaps := testSnapshots(t, 0)
+	snaps.Keep.All = true
+
+	if err := snaps.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := snaps.Exit(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countSnapshots(t, snaps); n != 0 {
+		t.Fatalf("Exit() without changes wrote %v snapshots; want none", n)
+	}
+
+	if err := snaps.Start(); err != nil {
+		t.Fatal(err)
+	}
+	snaps.Changed()
+	if err := snaps.Exit(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countSnapshots(t, snaps); n != 1 {
+		t.Errorf("Exit() after a change wrote %v snapshots; want 1", n)
+	}
+}
@@ -0,0 +1,214 @@
+// Package store is a small embedded key/value store shared by the modules.
+// Every write is its own committed transaction, and values are gob encoded.
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	ErrNotFound = errors.New("store: key not found")
+	// ErrClosed is returned by every method of a nil *Store, so modules whose
+	// store is only open while connected fail rather than panic
+	ErrClosed = errors.New("store: not open")
+)
+
+type Store struct {
+	db *bolt.DB
+}
+
+// Decoder decodes a stored value into v
+type Decoder func(v interface{}) error
+
+// Open opens (creating if needed) the store at path
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second * 5})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{db}, nil
+}
+
+func (self *Store) Close() error {
+	if self == nil {
+		return nil
+	}
+
+	return self.db.Close()
+}
+
+func (self *Store) Put(bucket, key string, value interface{}) error {
+	if self == nil {
+		return ErrClosed
+	}
+
+	data, err := encode(value)
+	if err != nil {
+		return err
+	}
+
+	return self.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(key), data)
+	})
+}
+
+// Get decodes the value at key into value, returning ErrNotFound if it does
+// not exist
+func (self *Store) Get(bucket, key string, value interface{}) error {
+	if self == nil {
+		return ErrClosed
+	}
+
+	return self.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return ErrNotFound
+		}
+
+		data := b.Get([]byte(key))
+		if data == nil {
+			return ErrNotFound
+		}
+
+		return decode(data, value)
+	})
+}
+
+func (self *Store) Delete(bucket, key string) error {
+	if self == nil {
+		return ErrClosed
+	}
+
+	return self.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+
+		return b.Delete([]byte(key))
+	})
+}
+
+// Drop deletes bucket and everything in it
+func (self *Store) Drop(bucket string) error {
+	if self == nil {
+		return ErrClosed
+	}
+
+	return self.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(bucket)) == nil {
+			return nil
+		}
+
+		return tx.DeleteBucket([]byte(bucket))
+	})
+}
+
+// ForEach calls fn for every key in bucket, stopping at the first error
+func (self *Store) ForEach(bucket string, fn func(key string, dec Decoder) error) error {
+	if self == nil {
+		return ErrClosed
+	}
+
+	return self.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			return fn(string(k), func(value interface{}) error {
+				return decode(v, value)
+			})
+		})
+	})
+}
+
+// Len returns the number of keys in bucket
+func (self *Store) Len(bucket string) (int, error) {
+	if self == nil {
+		return 0, ErrClosed
+	}
+
+	n := 0
+
+	err := self.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(bucket)); b != nil {
+			n = b.Stats().KeyN
+		}
+
+		return nil
+	})
+
+	return n, err
+}
+
+// Replace atomically swaps the contents of bucket for values
+func (self *Store) Replace(bucket string, values map[string]interface{}) error {
+	if self == nil {
+		return ErrClosed
+	}
+
+	encoded := make(map[string][]byte, len(values))
+
+	for key, value := range values {
+		data, err := encode(value)
+		if err != nil {
+			return err
+		}
+
+		encoded[key] = data
+	}
+
+	return self.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(bucket)) != nil {
+			if err := tx.DeleteBucket([]byte(bucket)); err != nil {
+				return err
+			}
+		}
+
+		b, err := tx.CreateBucket([]byte(bucket))
+		if err != nil {
+			return err
+		}
+
+		for key, data := range encoded {
+			if err := b.Put([]byte(key), data); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func encode(value interface{}) ([]byte, error) {
+	buf := bytes.Buffer{}
+
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decode(data []byte, value interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(value)
+}
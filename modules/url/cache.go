@@ -0,0 +1,343 @@
+package url
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crimsonvoid/ayuko/modules/store"
+)
+
+// cacheEntry is one cached preview, or the failure to make one
+type cacheEntry struct {
+	Key     string
+	Out     string
+	Err     string // Set for negatively cached failures
+	Generic bool   // Made by the generic title fallback
+	Expires time.Time
+}
+
+// previewCache is an LRU cache of previews keyed by canonicalURL()
+type previewCache struct {
+	Size   int
+	TTL    time.Duration
+	NegTTL time.Duration // TTL of failures
+
+	entries      map[string]*list.Element
+	order        *list.List // Most recently used first
+	hits, misses int
+
+	mut sync.Mutex
+}
+
+// cacheConfig is the toml configuration of a previewCache
+type cacheConfig struct {
+	Size        int    `toml:"size"`
+	TTL         string `toml:"ttl"`          // eg "1h"
+	NegativeTTL string `toml:"negative_ttl"` // eg "5m"
+	Persist     bool   `toml:"persist"`      // Keep the cache across restarts
+}
+
+// newPreviewCache() creates a cache with the defaults overridden by conf
+func newPreviewCache(conf cacheConfig) (*previewCache, error) {
+	self := &previewCache{
+		Size:   cacheSize,
+		TTL:    cacheTTL,
+		NegTTL: cacheNegTTL,
+
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+
+	if conf.Size > 0 {
+		self.Size = conf.Size
+	}
+	if conf.TTL != "" {
+		d, err := time.ParseDuration(conf.TTL)
+		if err != nil {
+			return nil, err
+		}
+		self.TTL = d
+	}
+	if conf.NegativeTTL != "" {
+		d, err := time.ParseDuration(conf.NegativeTTL)
+		if err != nil {
+			return nil, err
+		}
+		self.NegTTL = d
+	}
+
+	return self, nil
+}
+
+// Get() returns the unexpired entry for key
+func (self *previewCache) Get(key string) (cacheEntry, bool) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	elem, ok := self.entries[key]
+	if !ok {
+		self.misses++
+
+		return cacheEntry{}, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.Expires) {
+		self.remove(elem)
+		self.misses++
+
+		return cacheEntry{}, false
+	}
+
+	self.order.MoveToFront(elem)
+	self.hits++
+
+	return *entry, true
+}
+
+// Put() caches out, or err if it is set, for key
+func (self *previewCache) Put(key, out string, generic bool, err error) {
+	entry := &cacheEntry{Key: key, Out: out, Generic: generic}
+
+	if err != nil {
+		entry.Out, entry.Err = "", err.Error()
+		entry.Expires = time.Now().Add(self.NegTTL)
+	} else {
+		entry.Expires = time.Now().Add(self.TTL)
+	}
+
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	self.put(entry)
+}
+
+// put() does not lock. The callee should hold a lock
+func (self *previewCache) put(entry *cacheEntry) {
+	if elem, ok := self.entries[entry.Key]; ok {
+		self.remove(elem)
+	}
+
+	self.entries[entry.Key] = self.order.PushFront(entry)
+
+	for self.order.Len() > self.Size {
+		self.remove(self.order.Back())
+	}
+}
+
+// remove() does not lock. The callee should hold a lock
+func (self *previewCache) remove(elem *list.Element) {
+	delete(self.entries, elem.Value.(*cacheEntry).Key)
+	self.order.Remove(elem)
+}
+
+// Drop() removes key, reporting whether it was cached
+func (self *previewCache) Drop(key string) bool {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	elem, ok := self.entries[key]
+	if ok {
+		self.remove(elem)
+	}
+
+	return ok
+}
+
+// Flush() empties the cache
+func (self *previewCache) Flush() {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	self.entries = make(map[string]*list.Element)
+	self.order.Init()
+}
+
+// Entries() returns the unexpired entries, most recently used first
+func (self *previewCache) Entries() []cacheEntry {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	now := time.Now()
+	entries := make([]cacheEntry, 0, self.order.Len())
+
+	for elem := self.order.Front(); elem != nil; elem = elem.Next() {
+		if entry := elem.Value.(*cacheEntry); now.Before(entry.Expires) {
+			entries = append(entries, *entry)
+		}
+	}
+
+	return entries
+}
+
+func (self *previewCache) String() string {
+	self.mut.Lock()
+	hits, misses := self.hits, self.misses
+	self.mut.Unlock()
+
+	entries := self.Entries()
+	buf := &bytes.Buffer{}
+
+	fmt.Fprintf(buf, "%v/%v cached, %v hits, %v misses\n", len(entries), self.Size, hits, misses)
+
+	for _, entry := range entries {
+		result := entry.Out
+		if entry.Err != "" {
+			result = "error: " + entry.Err
+		}
+
+		fmt.Fprintf(buf, "%v (%v left) %v\n",
+			entry.Key, entry.Expires.Sub(time.Now())/time.Second*time.Second, result)
+	}
+
+	return buf.String()
+}
+
+// Save() writes the unexpired entries to fileName
+func (self *previewCache) Save(fileName string) error {
+	return store.WriteGob(fileName, self.Entries())
+}
+
+// Load() adds the unexpired entries in fileName, keeping their order
+func (self *previewCache) Load(fileName string) error {
+	file, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	entries := make([]cacheEntry, 0)
+	if err := gob.NewDecoder(file).Decode(&entries); err != nil {
+		return err
+	}
+
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	now := time.Now()
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entry := entries[i]; now.Before(entry.Expires) {
+			self.put(&entry)
+		}
+	}
+
+	return nil
+}
+
+// result() returns the cached preview or failure
+func (self *cacheEntry) result() (string, error) {
+	if self.Err != "" {
+		return "", errors.New(self.Err)
+	}
+
+	return self.Out, nil
+}
+
+// trackingParams are dropped from URLs before they are used as cache keys
+var trackingParams = map[string]bool{
+	"fbclid": true, "gclid": true, "dclid": true, "msclkid": true,
+	"mc_cid": true, "mc_eid": true, "igshid": true, "ref_src": true,
+}
+
+// ytTrackingParams are only tracking on YouTube, where share links add them
+var ytTrackingParams = map[string]bool{
+	"feature": true, "si": true,
+}
+
+// ytVidPaths are the YouTube paths that hold a video ID, like /shorts/ID
+var ytVidPaths = []string{"/shorts/", "/live/", "/embed/"}
+
+// canonicalURL() returns the cache key of uri. Links that show the same
+// preview, like youtu.be/ID and youtube.com/watch?v=ID, share a key
+func canonicalURL(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" {
+		return uri
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+
+	switch {
+	case u.Scheme == "http" && strings.HasSuffix(u.Host, ":80"):
+		u.Host = strings.TrimSuffix(u.Host, ":80")
+	case u.Scheme == "https" && strings.HasSuffix(u.Host, ":443"):
+		u.Host = strings.TrimSuffix(u.Host, ":443")
+	}
+
+	youtube := false
+
+	switch u.Host {
+	case "youtube.com", "m.youtube.com", "youtu.be":
+		youtube = true
+	}
+
+	query := u.Query()
+
+	for param := range query {
+		lower := strings.ToLower(param)
+
+		if trackingParams[lower] || strings.HasPrefix(lower, "utm_") || (youtube && ytTrackingParams[lower]) {
+			query.Del(param)
+		}
+	}
+
+	if youtube {
+		canonicalYoutube(u, query)
+	}
+
+	u.Fragment = ""
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	// Encode() sorts parameters, so their order does not matter either
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// canonicalYoutube() rewrites every link to a video as youtube.com/watch?v=ID.
+// The timestamp is kept as t=<seconds>, since it is part of the preview
+func canonicalYoutube(u *url.URL, query url.Values) {
+	id := ""
+
+	if u.Host == "youtu.be" {
+		id = strings.TrimPrefix(u.Path, "/")
+	}
+	for _, prefix := range ytVidPaths {
+		if strings.HasPrefix(u.Path, prefix) {
+			id = strings.TrimPrefix(u.Path, prefix)
+		}
+	}
+	id = strings.TrimSuffix(id, "/")
+
+	u.Host = "youtube.com"
+
+	if id != "" {
+		u.Path = "/watch"
+		query.Set("v", id)
+	}
+
+	secs, ok := ytTimestamp(u.String())
+
+	query.Del("t")
+	query.Del("start")
+
+	if ok && secs > 0 {
+		query.Set("t", strconv.Itoa(secs))
+	}
+}
@@ -1,27 +1,135 @@
 package url
 
 import (
+	"log"
+	"regexp"
+
 	"github.com/crimsonvoid/irclib/module"
 	irc "github.com/fluffle/goirc/client"
 )
 
-func registerCommands() {
-	regComParse()
+func (self *Module) registerCommands() {
+	self.Preconnect = func() error {
+		if !self.persist {
+			return nil
+		}
+
+		return self.cache.Load(self.dataDir + "cache.gob")
+	}
+	self.Disconnect = func() error {
+		if !self.persist {
+			return nil
+		}
+
+		return self.cache.Save(self.dataDir + "cache.gob")
+	}
+
+	self.regComParse()
+
+	if err := self.regConsCache(); err != nil {
+		panic(err)
+	}
 }
 
-func regComParse() {
-	Module.Register(module.E_PRIVMSG, urlRe, func(line *irc.Line) {
-		url := urlRe.FindString(line.Text())
-		if url == "" {
+func (self *Module) regComParse() {
+	self.Register(module.E_PRIVMSG, urlRe, func(line *irc.Line) {
+		urls := self.allowedURLs(line.Target(), line.Nick,
+			urlRe.FindAllString(line.Text(), -1))
+		if len(urls) == 0 {
 			return
 		}
 
-		title, err := Parse(url)
-		if err != nil {
-			Module.Logger.Errorf("[%v] - %v", url, err)
-			return
+		// Fetch concurrently, but announce in the order the links were posted
+		results := make([]chan string, len(urls))
+
+		for i, url := range urls {
+			results[i] = make(chan string, 1)
+			result, url := results[i], url
+
+			go self.workers.Do(func() {
+				title, err := self.Parse(line.Target(), url)
+				if err != nil {
+					self.Logger.Errorf("[%v] - %v", url, err)
+				}
+				if title == "" {
+					self.limits.Release(line.Target(), canonicalURL(url))
+				}
+
+				result <- title
+			})
 		}
 
-		Module.Conn.Privmsg(line.Target(), title)
+		go func() {
+			for _, result := range results {
+				if title := <-result; title != "" {
+					self.Conn.Privmsg(line.Target(), title)
+				}
+			}
+		}()
 	})
 }
+
+// allowedURLs() returns the distinct urls nick may have previewed in channel,
+// up to MaxURLs. Links no parser or domain list would preview are skipped
+// before they count against the limits
+func (self *Module) allowedURLs(channel, nick string, urls []string) []string {
+	allowed := make([]string, 0, self.limits.MaxURLs)
+	seen := make(map[string]bool, len(urls))
+
+	for _, url := range urls {
+		if len(allowed) >= self.limits.MaxURLs {
+			break
+		}
+
+		key := canonicalURL(url)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if !self.previewable(channel, url) {
+			continue
+		}
+		if self.limits.Allow(channel, nick, key) {
+			allowed = append(allowed, url)
+		}
+	}
+
+	return allowed
+}
+
+func (self *Module) regConsCache() error {
+	re := regexp.MustCompile(`^cache( (?P<flush>flush))?( (?P<url>\S+))?$`)
+	err := self.Console.Register(re, func(s string) {
+		groups, _ := matchGroups(re, s)
+		key := canonicalURL(groups["url"])
+
+		switch {
+		case groups["flush"] != "" && groups["url"] != "":
+			if self.cache.Drop(key) {
+				log.Printf("Dropped %v from the cache\n", key)
+			} else {
+				log.Printf("%v is not cached\n", key)
+			}
+		case groups["flush"] != "":
+			self.cache.Flush()
+			log.Println("Flushed the cache")
+		case groups["url"] != "":
+			// Entries() rather than Get() so looking does not count as a hit
+			for _, entry := range self.cache.Entries() {
+				if entry.Key == key {
+					out, err := entry.result()
+					log.Printf("%v (expires %v) %v %v\n", key, entry.Expires, out, err)
+
+					return
+				}
+			}
+
+			log.Printf("%v is not cached\n", key)
+		default:
+			log.Print(self.cache.String())
+		}
+	})
+
+	return err
+}
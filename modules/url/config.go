@@ -0,0 +1,121 @@
+package url
+
+import (
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+type config struct {
+	Url struct {
+		Fetch fetchConfig `toml:"fetch"` // [url.fetch]
+		Cache cacheConfig `toml:"cache"` // [url.cache]
+
+		Youtube youtubeConfig `toml:"youtube"` // [url.youtube]
+
+		Limits limitsConfig `toml:"limits"` // [url.limits]
+
+		// [url.generic] decides which links without a parser get their page
+		// title shown. "*" in a list matches every domain
+		Generic struct {
+			domainLists
+
+			// [url.generic.channels."#channel"] replaces the lists above in
+			// that channel
+			Channels map[string]domainLists `toml:"channels"`
+		} `toml:"generic"`
+	}
+}
+
+// domainLists allows and denies domains, and their subdomains. An empty
+// allow list allows every domain not denied
+type domainLists struct {
+	Allow []string `toml:"allow"`
+	Deny  []string `toml:"deny"`
+}
+
+func (self *Module) loadConfig(fileName string) error {
+	conf := config{}
+
+	if _, err := toml.DecodeFile(fileName, &conf); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var err error
+	if self.fetch, err = newFetcher(conf.Url.Fetch); err != nil {
+		return err
+	}
+
+	if conf.Url.Youtube.Key != "" {
+		self.youtube = newYoutubeClient(conf.Url.Youtube, self.fetch)
+	} else {
+		self.Logger.Infof("No YouTube API key, YouTube links get generic titles")
+	}
+
+	if self.cache, err = newPreviewCache(conf.Url.Cache); err != nil {
+		return err
+	}
+	self.persist = conf.Url.Cache.Persist
+
+	if self.limits, err = newThrottle(conf.Url.Limits); err != nil {
+		return err
+	}
+
+	workers := limitWorkers
+	if conf.Url.Limits.Workers > 0 {
+		workers = conf.Url.Limits.Workers
+	}
+	self.workers = newWorkerPool(workers)
+
+	self.generic = conf.Url.Generic.domainLists.normalize()
+	self.channels = make(map[string]domainLists, len(conf.Url.Generic.Channels))
+
+	for channel, lists := range conf.Url.Generic.Channels {
+		self.channels[strings.ToLower(channel)] = lists.normalize()
+	}
+
+	return nil
+}
+
+// normalize() lowercases domains and strips leading "*." and "."
+func (self domainLists) normalize() domainLists {
+	clean := func(domains []string) []string {
+		out := make([]string, 0, len(domains))
+
+		for _, domain := range domains {
+			domain = strings.ToLower(strings.TrimSpace(domain))
+			domain = strings.TrimPrefix(strings.TrimPrefix(domain, "*."), ".")
+
+			if domain != "" {
+				out = append(out, domain)
+			}
+		}
+
+		return out
+	}
+
+	return domainLists{clean(self.Allow), clean(self.Deny)}
+}
+
+// Allowed() reports whether host passes the lists. Deny wins over allow
+func (self domainLists) Allowed(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	if matchDomain(self.Deny, host) {
+		return false
+	}
+
+	return len(self.Allow) == 0 || matchDomain(self.Allow, host)
+}
+
+// matchDomain() reports whether host is, or is a subdomain of, any of domains
+func matchDomain(domains []string, host string) bool {
+	for _, domain := range domains {
+		if domain == "*" || host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,326 @@
+package url
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"code.google.com/p/go.net/html"
+	"code.google.com/p/go.net/html/charset"
+)
+
+// fetcher makes the HTTP requests behind link previews. It refuses to connect
+// to private, loopback and link-local addresses, checked after resolving the
+// host so neither DNS nor redirects can point it inside the network
+type fetcher struct {
+	ConnectTimeout time.Duration
+	Timeout        time.Duration // Whole request, including reading the body
+	MaxBody        int64         // Bytes read from a response body
+	MaxRedirects   int
+	UserAgent      string
+
+	client *http.Client
+}
+
+// fetchConfig is the toml configuration of a fetcher
+type fetchConfig struct {
+	ConnectTimeout string `toml:"connect_timeout"` // eg "5s"
+	Timeout        string `toml:"timeout"`         // eg "10s"
+	MaxBody        int64  `toml:"max_body"`        // Bytes
+	MaxRedirects   int    `toml:"max_redirects"`
+	UserAgent      string `toml:"user_agent"`
+}
+
+var (
+	errPrivateAddr = errors.New("Refusing to fetch from a private address")
+	errNoAddr      = errors.New("Host has no addresses")
+)
+
+// blockedNets are never fetched from
+var blockedNets = parseCIDRs(
+	"0.0.0.0/8",      // This network
+	"10.0.0.0/8",     // RFC1918
+	"100.64.0.0/10",  // Carrier-grade NAT
+	"127.0.0.0/8",    // Loopback
+	"169.254.0.0/16", // Link-local
+	"172.16.0.0/12",  // RFC1918
+	"192.0.0.0/24",   // IETF protocol assignments
+	"192.168.0.0/16", // RFC1918
+	"198.18.0.0/15",  // Benchmarking
+	"224.0.0.0/4",    // Multicast
+	"240.0.0.0/4",    // Reserved, broadcast
+	"::/128",         // Unspecified
+	"::1/128",        // Loopback
+	"64:ff9b::/96",   // NAT64, may map onto any of the above
+	"2001::/32",      // Teredo, embeds an IPv4 server and client
+	"2002::/16",      // 6to4, embeds an IPv4 gateway
+	"fc00::/7",       // Unique local
+	"fe80::/10",      // Link-local
+	"ff00::/8",       // Multicast
+)
+
+// newFetcher() creates a fetcher with the defaults overridden by conf
+func newFetcher(conf fetchConfig) (*fetcher, error) {
+	self := &fetcher{
+		ConnectTimeout: fetchConnectTimeout,
+		Timeout:        fetchTimeout,
+		MaxBody:        fetchMaxBody,
+		MaxRedirects:   fetchMaxRedirects,
+		UserAgent:      fetchUserAgent,
+	}
+
+	if conf.ConnectTimeout != "" {
+		d, err := time.ParseDuration(conf.ConnectTimeout)
+		if err != nil {
+			return nil, err
+		}
+		self.ConnectTimeout = d
+	}
+	if conf.Timeout != "" {
+		d, err := time.ParseDuration(conf.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		self.Timeout = d
+	}
+	if conf.MaxBody > 0 {
+		self.MaxBody = conf.MaxBody
+	}
+	if conf.MaxRedirects > 0 {
+		self.MaxRedirects = conf.MaxRedirects
+	}
+	if conf.UserAgent != "" {
+		self.UserAgent = conf.UserAgent
+	}
+
+	self.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 nil, // A proxy would dial for us, past dial()
+			Dial:                  self.dial,
+			TLSHandshakeTimeout:   self.ConnectTimeout,
+			ResponseHeaderTimeout: self.Timeout,
+		},
+		CheckRedirect: self.checkRedirect,
+		Timeout:       self.Timeout,
+	}
+
+	return self, nil
+}
+
+// Get() fetches url. The response body stops after MaxBody bytes
+func (self *fetcher) Get(url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("Unsupported scheme %v", req.URL.Scheme)
+	}
+
+	req.Header.Set("User-Agent", self.UserAgent)
+
+	resp, err := self.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := respOkay(resp); err != nil {
+		resp.Body.Close()
+
+		return nil, err
+	}
+
+	resp.Body = limitedBody{io.LimitReader(resp.Body, self.MaxBody), resp.Body}
+
+	return resp, nil
+}
+
+// JSON() decodes the JSON document at url into data
+func (self *fetcher) JSON(url string, data interface{}) error {
+	resp, err := self.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(data)
+}
+
+// Meta() reads the title and meta tags of the page at url. The page is
+// decoded from its charset and read only up to the end of its <head>
+func (self *fetcher) Meta(url string) (*pageMeta, error) {
+	resp, err := self.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := charset.NewReader(resp.Body, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+
+	return readHead(html.NewTokenizer(body))
+}
+
+// readHead() tokenizes up to </head>, or whatever starts the body in pages
+// that leave it out
+func readHead(z *html.Tokenizer) (*pageMeta, error) {
+	meta := &pageMeta{}
+	inTitle, seenTitle := false, false
+
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			// A page cut off by MaxBody may still have had its title
+			if z.Err() == io.EOF || meta.Best() != "" {
+				return meta, nil
+			}
+
+			return nil, z.Err()
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+
+			switch tok.Data {
+			case "title":
+				inTitle = !seenTitle
+				seenTitle = true
+			case "meta":
+				meta.readTag(tok.Attr)
+			case "body":
+				return meta, nil
+			}
+		case html.EndTagToken:
+			switch name, _ := z.TagName(); string(name) {
+			case "title":
+				inTitle = false
+			case "head":
+				return meta, nil
+			}
+		case html.TextToken:
+			if inTitle {
+				meta.Title += string(z.Text())
+			}
+		}
+	}
+}
+
+// dial() connects to the first public address addr's host resolves to
+func (self *fetcher) dial(network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := net.Dialer{Timeout: self.ConnectTimeout}
+	err = errNoAddr
+
+	// Dial the address that was checked, not the name, so a second lookup
+	// can not answer differently
+	for _, ip := range ips {
+		if !publicIP(ip) {
+			err = errPrivateAddr
+			continue
+		}
+
+		var conn net.Conn
+		if conn, err = dialer.Dial(network, net.JoinHostPort(ip.String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
+
+// checkRedirect() limits redirects to MaxRedirects http(s) hops
+func (self *fetcher) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= self.MaxRedirects {
+		return fmt.Errorf("Stopped after %v redirects", len(via))
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("Redirected to unsupported scheme %v", req.URL.Scheme)
+	}
+
+	req.Header.Set("User-Agent", self.UserAgent)
+
+	return nil
+}
+
+// publicIP() reports whether ip is outside every blocked network
+func publicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	for _, block := range blockedNets {
+		if block.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+
+		nets = append(nets, ipNet)
+	}
+
+	return nets
+}
+
+// limitedBody reads through a LimitReader and closes the underlying body
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// readTag() fills in the field of meta a <meta> tag with attrs describes,
+// unless an earlier tag already did
+func (self *pageMeta) readTag(attrs []html.Attribute) {
+	var key, content string
+
+	for _, attr := range attrs {
+		switch strings.ToLower(attr.Key) {
+		case "property", "name":
+			if key == "" {
+				key = strings.ToLower(attr.Val)
+			}
+		case "content":
+			content = attr.Val
+		}
+	}
+
+	var field *string
+	switch key {
+	case "og:title":
+		field = &self.OgTitle
+	case "og:site_name":
+		field = &self.SiteName
+	case "twitter:title":
+		field = &self.TwTitle
+	default:
+		return
+	}
+
+	if *field == "" {
+		*field = content
+	}
+}
@@ -1,7 +1,6 @@
 package url
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -10,83 +9,153 @@ import (
 	"strings"
 	"time"
 
-	"code.google.com/p/go.net/html"
 	"github.com/crimsonvoid/irclib/styles"
 )
 
-func Parse(url string) (string, error) {
+// Parse() describes url with the parser matching it, falling back to the
+// page's title when channel's lists allow url's domain. Results, including
+// failures, are cached
+func (self *Module) Parse(channel, url string) (string, error) {
+	key := canonicalURL(url)
+	allowed := self.genericAllowed(channel, url)
+
+	if entry, ok := self.cache.Get(key); ok {
+		if entry.Generic && !allowed {
+			return "", errNoMatch
+		}
+
+		return entry.result()
+	}
+
+	// Empty output is not a preview; it is tried again next time
+	out, generic, err := self.describe(url, allowed)
+	if err != errNoMatch && (out != "" || err != nil) {
+		self.cache.Put(key, out, generic, err)
+	}
+
+	return out, err
+}
+
+// describe() does the work of Parse(). generic is true when the output came
+// from the generic title fallback
+func (self *Module) describe(url string, allowed bool) (out string, generic bool, err error) {
 	for _, parser := range parseMap {
 		if !parser.re.MatchString(url) {
 			continue
 		}
 
-		out, err := parser.fn(parser.re, url)
-		if err == nil {
-			return out, err
+		out, err := parser.fn(self, parser.re, url)
+		if err == errNoMatch {
+			break // Parser disabled
+		}
+		if err == nil || !allowed {
+			return out, false, err
 		}
 
-		Module.Logger.Errorf("Parse(%v) %v", url, err)
+		self.Logger.Errorf("Parse(%v) %v", url, err)
 
 		break
 	}
 
-	// return genericParser(url)
-	return "", errors.New("No match")
+	if !allowed {
+		return "", false, errNoMatch
+	}
+
+	out, err = self.genericTitle(url)
+
+	return out, true, err
+}
+
+// genericAllowed() reports whether channel shows titles for url's domain
+func (self *Module) genericAllowed(channel, uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	lists, ok := self.channels[strings.ToLower(channel)]
+	if !ok {
+		lists = self.generic
+	}
+
+	return lists.Allowed(hostname(u.Host))
 }
 
-func ytVidParser(re *regexp.Regexp, uri string) (string, error) {
+// previewable() reports whether Parse() could preview uri in channel, either
+// with a parser or with the generic title fallback
+func (self *Module) previewable(channel, uri string) bool {
+	for _, parser := range parseMap {
+		if parser.re.MatchString(uri) {
+			return true
+		}
+	}
+
+	return self.genericAllowed(channel, uri)
+}
+
+func (self *Module) ytVidParser(re *regexp.Regexp, uri string) (string, error) {
 	groups, err := matchGroups(re, uri)
 	if err != nil {
 		return "", err
 	}
 
-	jData := ytVidJSON{}
-	if err = decodeJSON(fmt.Sprintf(ytVidAPI, groups["id"]), &jData); err != nil {
+	if self.youtube == nil {
+		return "", errNoMatch
+	}
+
+	video, err := self.youtube.Video(groups["id"])
+	if err != nil {
 		return "", err
 	}
-	data := jData.Data
 
 	timeQuery := ""
-	if u, err := url.Parse(uri); err == nil {
-		vals := u.Query()
-
-		if t := vals.Get("t"); t != "" {
-			timeQuery = "?t=" + t
-		}
+	if secs, ok := ytTimestamp(uri); ok && secs > 0 {
+		timeQuery = fmt.Sprintf("?t=%v", secs)
 	}
 
-	duration, err := time.ParseDuration(fmt.Sprintf("%ds", data.Duration))
-	if err != nil {
-		return "", err
+	details := []string{video.Status(time.Now())}
+
+	// Upcoming videos have no views yet, and streams may not report them
+	if views := video.Statistics.ViewCount; views != "" && video.Snippet.LiveBroadcastContent != "upcoming" {
+		details = append(details, commas(views)+" views")
+	}
+	if likes := video.Statistics.LikeCount; likes != "" {
+		details = append(details, commas(likes)+" likes")
+	}
+	if video.AgeRestricted() {
+		details = append(details, styles.LightRed.Fg("18+"))
 	}
 
 	return fmt.Sprintf("[https://youtu.be/%v%v] %v - %v (%v)",
-		data.Id, timeQuery,
-		data.Uploader,
-		styles.Bold.Paint("%v", data.Title),
-		duration), nil
+		video.Id, timeQuery,
+		video.Snippet.ChannelTitle,
+		styles.Bold.Paint("%v", video.Snippet.Title),
+		strings.Join(details, ", ")), nil
 }
 
-func ytPLParser(re *regexp.Regexp, url string) (string, error) {
+func (self *Module) ytPLParser(re *regexp.Regexp, url string) (string, error) {
 	groups, err := matchGroups(re, url)
 	if err != nil {
 		return "", err
 	}
 
-	jData := ytPLJSON{}
-	if err = decodeJSON(fmt.Sprintf(ytPLAPI, groups["id"]), &jData); err != nil {
+	if self.youtube == nil {
+		return "", errNoMatch
+	}
+
+	playlist, err := self.youtube.Playlist(groups["id"])
+	if err != nil {
 		return "", err
 	}
-	data := jData.Data
 
 	return fmt.Sprintf("[https://youtube.com/playlist?list=%v] %v - %v (%v videos)",
-		data.Id,
-		data.Author,
-		styles.Bold.Paint("%v", data.Title),
-		data.TotalItems), nil
+		playlist.Id,
+		playlist.Snippet.ChannelTitle,
+		styles.Bold.Paint("%v", playlist.Snippet.Title),
+		playlist.ContentDetails.ItemCount), nil
 }
 
-func githubParser(re *regexp.Regexp, url string) (string, error) {
+func (self *Module) githubParser(re *regexp.Regexp, url string) (string, error) {
 	groups, err := matchGroups(re, url)
 	if err != nil {
 		return "", err
@@ -96,8 +165,8 @@ func githubParser(re *regexp.Regexp, url string) (string, error) {
 	repoData := githubRepoJSON{}
 
 	// Need to pull repo data regardless
-	if err := decodeJSON(api, &repoData); err != nil {
-		return "", nil
+	if err := self.fetch.JSON(api, &repoData); err != nil {
+		return "", err
 	}
 
 	// Default info
@@ -114,7 +183,7 @@ func githubParser(re *regexp.Regexp, url string) (string, error) {
 			jData := githubIssueJSON{}
 			api += fmt.Sprintf("/issues/%v", id)
 
-			if err := decodeJSON(api, &jData); err != nil {
+			if err := self.fetch.JSON(api, &jData); err != nil {
 				break
 			}
 
@@ -133,7 +202,7 @@ func githubParser(re *regexp.Regexp, url string) (string, error) {
 			jData := githubCommitJSON{}
 			api += fmt.Sprintf("/commits/%v", id)
 
-			if err := decodeJSON(api, &jData); err != nil {
+			if err := self.fetch.JSON(api, &jData); err != nil {
 				break
 			}
 
@@ -154,14 +223,14 @@ func githubParser(re *regexp.Regexp, url string) (string, error) {
 	), nil
 }
 
-func vimeoParser(re *regexp.Regexp, url string) (string, error) {
+func (self *Module) vimeoParser(re *regexp.Regexp, url string) (string, error) {
 	groups, err := matchGroups(re, url)
 	if err != nil {
 		return "", err
 	}
 
 	jData := make([]vimeoJSON, 0, 1)
-	if err = decodeJSON(fmt.Sprintf(vimeoAPI, groups["id"]), &jData); err != nil {
+	if err = self.fetch.JSON(fmt.Sprintf(vimeoAPI, groups["id"]), &jData); err != nil {
 		return "", err
 	}
 	if len(jData) == 0 {
@@ -182,28 +251,28 @@ func vimeoParser(re *regexp.Regexp, url string) (string, error) {
 		duration), nil
 }
 
-func steamParser(re *regexp.Regexp, url string) (string, error) {
+func (self *Module) steamParser(re *regexp.Regexp, url string) (string, error) {
 	groups, err := matchGroups(re, url)
 	if err != nil {
 		return "", err
 	}
 
-	return parseTitle(fmt.Sprintf("http://store.steampowered.com/app/%v",
+	return self.parseTitle(fmt.Sprintf("http://store.steampowered.com/app/%v",
 		groups["id"]))
 }
 
-func hnParser(re *regexp.Regexp, url string) (string, error) {
+func (self *Module) hnParser(re *regexp.Regexp, url string) (string, error) {
 	groups, err := matchGroups(re, url)
 	if err != nil {
 		return "", err
 	}
 
-	return parseTitle(fmt.Sprintf("https://news.ycombinator.com/item?id=%v",
+	return self.parseTitle(fmt.Sprintf("https://news.ycombinator.com/item?id=%v",
 		groups["id"]))
 }
 
-func parseTitle(url string) (string, error) {
-	title, err := genericParser(url)
+func (self *Module) parseTitle(url string) (string, error) {
+	title, err := self.genericParser(url)
 	if err != nil {
 		return "", err
 	}
@@ -214,78 +283,59 @@ func parseTitle(url string) (string, error) {
 	), nil
 }
 
-func decodeJSON(url string, data interface{}) error {
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if err := respOkay(resp); err != nil {
-		return err
-	}
+// pageMeta is what a page's <head> says about itself
+type pageMeta struct {
+	Title    string // <title>
+	OgTitle  string // og:title
+	SiteName string // og:site_name
+	TwTitle  string // twitter:title
+}
 
-	dec := json.NewDecoder(resp.Body)
-	if err = dec.Decode(data); err != nil {
-		return err
+// Best() returns the most descriptive title the page has
+func (self *pageMeta) Best() string {
+	for _, title := range []string{self.OgTitle, self.TwTitle, self.Title} {
+		if title = cleanText(title); title != "" {
+			return title
+		}
 	}
 
-	return nil
+	return ""
 }
 
-// Returns the <title> of `url`
-func genericParser(url string) (string, error) {
-	resp, err := http.Get(url)
+// genericTitle() returns "[site] title" for any page with a title
+func (self *Module) genericTitle(uri string) (string, error) {
+	meta, err := self.fetch.Meta(uri)
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
 
-	if err := respOkay(resp); err != nil {
-		return "", err
+	title := meta.Best()
+	if title == "" {
+		return "", errors.New("No title attribute")
 	}
 
-	// Tokenizer
-	tkn, err := html.Parse(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	return findTitle(tkn)
-}
-
-func findTitle(n *html.Node) (string, error) {
-	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		// Get title from <head> element
-		if c.Data == "head" {
-			return getTitle(c)
-		}
-
-		// Parse child nodes only if they are html.(Document|Element)Nodes
-		if fChild := c.FirstChild; fChild != nil &&
-			(fChild.Type == html.DocumentNode || fChild.Type == html.ElementNode) {
-			return findTitle(c)
+	site := cleanText(meta.SiteName)
+	if site == "" {
+		if u, err := url.Parse(uri); err == nil {
+			site = strings.TrimPrefix(hostname(u.Host), "www.")
 		}
 	}
 
-	return "", errors.New("head attribute not found")
+	return fmt.Sprintf("[%v] %v",
+		site,
+		styles.Bold.Paint("%v", truncate(title, maxContentLen)),
+	), nil
 }
 
-// Find "<title>" tag, returning an error if the tag is not found
-func getTitle(n *html.Node) (string, error) {
-	for c := n.FirstChild; c != nil; c = c.NextSibling {
-		// Only parse (Document|Element)Node's
-		// continue if not <title> tag
-		if (c.Type != html.DocumentNode && c.Type != html.ElementNode) || c.Data != "title" {
-			continue
-		}
-
-		if child := c.FirstChild; child != nil && child.Type == html.TextNode {
-			return child.Data, nil
-		}
+// Returns the <title> of `url`
+func (self *Module) genericParser(url string) (string, error) {
+	meta, err := self.fetch.Meta(url)
+	if err != nil {
+		return "", err
+	}
 
-		// break if first <title> tag not html.TextNode
-		break
+	if title := cleanText(meta.Title); title != "" {
+		return truncate(title, maxContentLen), nil
 	}
 
 	return "", errors.New("No title attribute")
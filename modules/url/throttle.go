@@ -0,0 +1,191 @@
+package url
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// throttle limits how many previews channels and nicks get, and stops the
+// same link being previewed twice in a channel in quick succession
+type throttle struct {
+	MaxURLs       int // Previews per message
+	ChannelLimit  int // Previews per ChannelWindow in one channel; < 1 is unlimited
+	ChannelWindow time.Duration
+	NickLimit     int // Previews per NickWindow for one nick; < 1 is unlimited
+	NickWindow    time.Duration
+	RepeatWindow  time.Duration // A link is not previewed again within this
+
+	channels  map[string][]time.Time // map[lowercase channel]preview times
+	nicks     map[string][]time.Time // map[lowercase nick]preview times
+	announced map[string]time.Time   // map[lowercase channel + " " + key]last allowed
+	lastSweep time.Time
+
+	mut sync.Mutex
+}
+
+// limitsConfig is the toml configuration of a throttle and the worker pool
+type limitsConfig struct {
+	MaxURLs       int    `toml:"max_urls"`
+	Workers       int    `toml:"workers"`
+	ChannelLimit  int    `toml:"channel_limit"`  // -1 is unlimited
+	ChannelWindow string `toml:"channel_window"` // eg "1m"
+	NickLimit     int    `toml:"nick_limit"`     // -1 is unlimited
+	NickWindow    string `toml:"nick_window"`    // eg "1m"
+	RepeatWindow  string `toml:"repeat_window"`  // eg "10m"; "0s" disables
+}
+
+// newThrottle() creates a throttle with the defaults overridden by conf
+func newThrottle(conf limitsConfig) (*throttle, error) {
+	self := &throttle{
+		MaxURLs:       limitMaxURLs,
+		ChannelLimit:  limitChannel,
+		ChannelWindow: limitChannelWindow,
+		NickLimit:     limitNick,
+		NickWindow:    limitNickWindow,
+		RepeatWindow:  limitRepeatWindow,
+
+		channels:  make(map[string][]time.Time),
+		nicks:     make(map[string][]time.Time),
+		announced: make(map[string]time.Time),
+	}
+
+	if conf.MaxURLs > 0 {
+		self.MaxURLs = conf.MaxURLs
+	}
+	if conf.ChannelLimit != 0 {
+		self.ChannelLimit = conf.ChannelLimit
+	}
+	if conf.NickLimit != 0 {
+		self.NickLimit = conf.NickLimit
+	}
+
+	durations := []struct {
+		conf string
+		d    *time.Duration
+	}{
+		{conf.ChannelWindow, &self.ChannelWindow},
+		{conf.NickWindow, &self.NickWindow},
+		{conf.RepeatWindow, &self.RepeatWindow},
+	}
+
+	for _, dur := range durations {
+		if dur.conf == "" {
+			continue
+		}
+
+		d, err := time.ParseDuration(dur.conf)
+		if err != nil {
+			return nil, err
+		}
+		*dur.d = d
+	}
+
+	return self, nil
+}
+
+// Allow() reports whether nick's link, with cache key key, may be previewed
+// in channel, and if so counts it against both of their limits and reserves
+// it, so the link is a repeat until Release() is called for it
+func (self *throttle) Allow(channel, nick, key string) bool {
+	channel, nick = strings.ToLower(channel), strings.ToLower(nick)
+	seen := channel + " " + key
+
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	now := time.Now()
+
+	if now.Sub(self.lastSweep) > self.ChannelWindow+self.NickWindow+self.RepeatWindow {
+		self.sweep(now)
+	}
+
+	if last, ok := self.announced[seen]; ok && now.Sub(last) < self.RepeatWindow {
+		return false
+	}
+
+	channelHits := recent(self.channels[channel], now, self.ChannelWindow)
+	nickHits := recent(self.nicks[nick], now, self.NickWindow)
+
+	if (self.ChannelLimit > 0 && len(channelHits) >= self.ChannelLimit) ||
+		(self.NickLimit > 0 && len(nickHits) >= self.NickLimit) {
+		self.channels[channel], self.nicks[nick] = channelHits, nickHits
+
+		return false
+	}
+
+	self.channels[channel] = append(channelHits, now)
+	self.nicks[nick] = append(nickHits, now)
+	self.announced[seen] = now
+
+	return true
+}
+
+// Release() lets the link with cache key key be previewed in channel again,
+// after Allow() reserved it but nothing was previewed
+func (self *throttle) Release(channel, key string) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	delete(self.announced, strings.ToLower(channel)+" "+key)
+}
+
+// sweep() does not lock. The callee should hold a lock
+func (self *throttle) sweep(now time.Time) {
+	self.lastSweep = now
+
+	for channel, hits := range self.channels {
+		if hits = recent(hits, now, self.ChannelWindow); len(hits) == 0 {
+			delete(self.channels, channel)
+		} else {
+			self.channels[channel] = hits
+		}
+	}
+
+	for nick, hits := range self.nicks {
+		if hits = recent(hits, now, self.NickWindow); len(hits) == 0 {
+			delete(self.nicks, nick)
+		} else {
+			self.nicks[nick] = hits
+		}
+	}
+
+	for seen, last := range self.announced {
+		if now.Sub(last) >= self.RepeatWindow {
+			delete(self.announced, seen)
+		}
+	}
+}
+
+// recent() returns the times, oldest first, within window of now
+func recent(times []time.Time, now time.Time, window time.Duration) []time.Time {
+	i := 0
+	for ; i < len(times) && now.Sub(times[i]) >= window; i++ {
+	}
+
+	return times[i:]
+}
+
+// workerPool runs jobs on a fixed number of goroutines
+type workerPool struct {
+	jobs chan func()
+}
+
+func newWorkerPool(workers int) *workerPool {
+	self := &workerPool{jobs: make(chan func())}
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range self.jobs {
+				job()
+			}
+		}()
+	}
+
+	return self
+}
+
+// Do() queues job, blocking until a worker takes it
+func (self *workerPool) Do(job func()) {
+	self.jobs <- job
+}
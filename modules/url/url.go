@@ -1,24 +1,27 @@
 package url
 
 import (
-	"fmt"
-	"path/filepath"
-	"runtime"
-
+	"github.com/crimsonvoid/ayuko/modules/store"
 	"github.com/crimsonvoid/irclib/module"
 )
 
-func init() {
-	_, file, _, _ := runtime.Caller(0)
-	base := filepath.Base(file)
-	ext := filepath.Ext(base)
-	var err error
-
-	Module, err = module.New(fmt.Sprintf("data%[1]cconfs%[1]c%v.toml",
-		filepath.Separator, base[:len(base)-len(ext)]))
+// New() creates a url module configured by confFile that keeps its cache, when
+// persisted, in dataDir, or defaultDataDir if it is empty
+func New(confFile, dataDir string) (*Module, error) {
+	mod, err := module.New(confFile)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	self := &Module{
+		Module:  mod,
+		dataDir: store.DataDir(dataDir, defaultDataDir),
+	}
+	if err := self.loadConfig(confFile); err != nil {
+		return nil, err
 	}
 
-	registerCommands()
+	self.registerCommands()
+
+	return self, nil
 }
@@ -0,0 +1,209 @@
+package url
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/crimsonvoid/ayuko/harness"
+)
+
+// startUrl() runs a url module configured by conf whose fetches all go to
+// srv, whatever host they name
+func startUrl(t *testing.T, conf string, srv *httptest.Server) *harness.Bot {
+	dir := t.TempDir()
+
+	confFile := filepath.Join(dir, "url.toml")
+	if err := ioutil.WriteFile(confFile, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mod, err := New(confFile, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod.fetch.client.Transport = &http.Transport{
+		Dial: func(network, _ string) (net.Conn, error) {
+			return net.Dial(network, srv.Listener.Addr().String())
+		},
+	}
+
+	return harness.StartModule(t, mod.Module)
+}
+
+func pageServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, "<html><head><title>Page at %v%v</title></head><body></body></html>",
+			r.Host, r.URL.Path)
+	}))
+}
+
+func TestGenericTitle(t *testing.T) {
+	srv := pageServer()
+	defer srv.Close()
+
+	bot := startUrl(t, "", srv)
+
+	msgs, err := bot.Say("alice", harness.Channel, "look at http://example.com/news please", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Target != harness.Channel ||
+		!strings.Contains(msgs[0].Text, "Page at example.com/news") {
+		t.Fatalf("got %v; want the page title in %v", msgs, harness.Channel)
+	}
+}
+
+func TestGenericDenied(t *testing.T) {
+	srv := pageServer()
+	defer srv.Close()
+
+	bot := startUrl(t, `
+[url.generic]
+deny = ["example.com"]
+`, srv)
+
+	if msgs, _ := bot.Say("alice", harness.Channel, "http://www.example.com/news", 0); len(msgs) != 0 {
+		t.Errorf("denied domain: got %v; want no reply", msgs)
+	}
+
+	msgs, err := bot.Say("alice", harness.Channel, "http://example.org/news", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || !strings.Contains(msgs[0].Text, "example.org/news") {
+		t.Errorf("other domain: got %v; want its title", msgs)
+	}
+}
+
+func TestSeveralLinksInOrder(t *testing.T) {
+	srv := pageServer()
+	defer srv.Close()
+
+	bot := startUrl(t, "", srv)
+
+	msgs, err := bot.Say("alice", harness.Channel,
+		"http://example.com/one http://example.com/two http://example.com/one", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs = append(msgs, bot.Quiet()...)
+	if len(msgs) != 2 || !strings.Contains(msgs[0].Text, "/one") || !strings.Contains(msgs[1].Text, "/two") {
+		t.Errorf("got %v; want /one then /two, once each", msgs)
+	}
+}
+
+func TestDeniedLinksNotThrottled(t *testing.T) {
+	srv := pageServer()
+	defer srv.Close()
+
+	bot := startUrl(t, `
+[url.generic]
+deny = ["example.com"]
+
+[url.limits]
+nick_limit = 1
+`, srv)
+
+	msgs, err := bot.Say("alice", harness.Channel, "http://example.com/news http://example.org/news", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || !strings.Contains(msgs[0].Text, "example.org/news") {
+		t.Errorf("got %v; want the allowed link previewed despite the denied one", msgs)
+	}
+}
+
+func TestRepeatReservedUntilReleased(t *testing.T) {
+	limits, err := newThrottle(limitsConfig{ChannelLimit: -1, NickLimit: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := canonicalURL("http://example.com/news")
+
+	if !limits.Allow("#Test", "alice", key) {
+		t.Fatal("Allow() refused a link that was never previewed")
+	}
+	if limits.Allow("#test", "bob", key) {
+		t.Error("Allow() allowed a link already reserved in the channel")
+	}
+	if !limits.Allow("#other", "alice", key) {
+		t.Error("Allow() refused a link reserved in another channel")
+	}
+
+	limits.Release("#TEST", key)
+
+	if !limits.Allow("#test", "bob", key) {
+		t.Error("Allow() refused a released link")
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":            true,
+		"2606:2800:220:1::1":       true,
+		"127.0.0.1":                false,
+		"::ffff:10.0.0.1":          false,
+		"64:ff9b::7f00:1":          false,
+		"2002:7f00:1::1":           false, // 6to4 gateway 127.0.0.1
+		"2001:0:4136:e378::c0a8:1": false, // Teredo
+	} {
+		if got := publicIP(net.ParseIP(addr)); got != want {
+			t.Errorf("publicIP(%v) = %v; want %v", addr, got, want)
+		}
+	}
+}
+
+func TestCanonicalURL(t *testing.T) {
+	for uri, want := range map[string]string{
+		"https://youtu.be/dQw4w9WgXcQ?si=abc":                      "https://youtube.com/watch?v=dQw4w9WgXcQ",
+		"https://www.youtube.com/shorts/dQw4w9WgXcQ?feature=share": "https://youtube.com/watch?v=dQw4w9WgXcQ",
+		"https://m.youtube.com/live/dQw4w9WgXcQ":                   "https://youtube.com/watch?v=dQw4w9WgXcQ",
+		"https://youtube.com/embed/dQw4w9WgXcQ?start=90":           "https://youtube.com/watch?t=90&v=dQw4w9WgXcQ",
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ#t=1m30s":      "https://youtube.com/watch?t=90&v=dQw4w9WgXcQ",
+		"https://example.com/page?si=1&feature=2&utm_source=x#top": "https://example.com/page?feature=2&si=1",
+		"HTTP://Example.com:80?fbclid=1":                           "http://example.com/",
+	} {
+		if got := canonicalURL(uri); got != want {
+			t.Errorf("canonicalURL(%q) = %q; want %q", uri, got, want)
+		}
+	}
+}
+
+func TestGithubFailureNotCachedAsPreview(t *testing.T) {
+	dir := t.TempDir()
+
+	confFile := filepath.Join(dir, "url.toml")
+	if err := ioutil.WriteFile(confFile, []byte("[url.generic]\ndeny = [\"github.com\"]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mod, err := New(confFile, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod.fetch.client.Transport = &http.Transport{
+		Dial: func(string, string) (net.Conn, error) {
+			return nil, errors.New("api unavailable")
+		},
+	}
+
+	uri := "https://github.com/crimsonvoid/ayuko"
+
+	if out, err := mod.Parse(harness.Channel, uri); err == nil {
+		t.Fatalf("Parse() with the API down = %q; want an error", out)
+	}
+
+	entry, ok := mod.cache.Get(canonicalURL(uri))
+	if !ok || entry.Err == "" {
+		t.Errorf("cache entry = %+v, %v; want the failure, cached for the negative TTL", entry, ok)
+	}
+}
@@ -2,7 +2,11 @@ package url
 
 import (
 	"fmt"
+	"net"
 	"regexp"
+	"strings"
+
+	"code.google.com/p/go.net/html"
 )
 
 func matchGroups(reg *regexp.Regexp, s string) (map[string]string, error) {
@@ -26,6 +30,29 @@ func cleanHTML(com string) string {
 	return htmlCleanerR.ReplaceAllLiteralString(com, " ")
 }
 
+// cleanText() decodes leftover entities and collapses whitespace
+func cleanText(s string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+}
+
+// truncate() cuts s to at most n runes, marking the cut with "..."
+func truncate(s string, n int) string {
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n]) + "..."
+	}
+
+	return s
+}
+
+// hostname() strips the port from host
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+
+	return host
+}
+
 func takeWhile(s string, f func(rune) bool) string {
 	end := 0
 
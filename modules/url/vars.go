@@ -1,54 +1,72 @@
 package url
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
+	"time"
 
 	"github.com/crimsonvoid/irclib/module"
 )
 
 const (
 	maxContentLen = 100
+
+	fetchConnectTimeout = time.Second * 5
+	fetchTimeout        = time.Second * 10
+	fetchMaxBody        = 1 << 20
+	fetchMaxRedirects   = 5
+	fetchUserAgent      = "Ayuko (IRC link previews)"
+
+	youtubeAPI = "https://www.googleapis.com/youtube/v3"
+
+	cacheSize   = 256
+	cacheTTL    = time.Hour
+	cacheNegTTL = time.Minute * 5
+
+	limitMaxURLs       = 3
+	limitWorkers       = 4
+	limitChannel       = 6
+	limitChannelWindow = time.Minute
+	limitNick          = 3
+	limitNickWindow    = time.Minute
+	limitRepeatWindow  = time.Minute * 10
+
+	defaultDataDir = "./data/url/"
 )
 
+var errNoMatch = errors.New("No match")
+
 var (
 	htmlCleanerR = regexp.MustCompile(fmt.Sprintf(`</?[%v].*?>`,
 		`a|br|code|span|wbr`,
 	))
 
 	urlRe = regexp.MustCompile(`(http|https)\://[a-zA-Z0-9\-\.]+\.[a-zA-Z]{2,3}(:[a-zA-Z0-9]*)?/?([a-zA-Z0-9\-\._\?\,\'/\\\+&amp;%\$#\=~])*`)
-
-	Module *module.Module
 )
 
-// YouTube - Video
-type ytVidJSON struct {
-	Data struct {
-		Id       string
-		Uploader string
-		Title    string
-		Duration int
-	}
-}
+// Module is one instance of the url module
+type Module struct {
+	*module.Module
 
-var (
-	ytVidRegexp = regexp.MustCompile(`youtu(be\.com/watch\?v=|\.be/)(?P<id>[\w_\-]{11})`)
-	ytVidAPI    = "https://gdata.youtube.com/feeds/api/videos/%v?v=2&alt=jsonc"
-)
+	fetch   *fetcher
+	youtube *youtubeClient
+	cache   *previewCache
+	dataDir string
+	persist bool // Save the cache on Disconnect and load it on Preconnect
 
-// Youtube - Playlist
-type ytPLJSON struct {
-	Data struct {
-		Id         string
-		Author     string
-		Title      string
-		TotalItems int
-	}
+	limits  *throttle
+	workers *workerPool // Shared by every message, bounding concurrent fetches
+
+	generic  domainLists            // Generic title lists for channels not in channels
+	channels map[string]domainLists // map[lowercase channel]lists
 }
 
+// YouTube - watch, youtu.be, Shorts, live and embed links to a video
 var (
-	ytPLRegexp = regexp.MustCompile(`youtube\.com/playlist\?list=(?P<id>(PL)?[\w_\-]+)`)
-	ytPLAPI    = "https://gdata.youtube.com/feeds/api/playlists/%v?v=2&alt=jsonc"
+	ytVidRegexp = regexp.MustCompile(
+		`youtu(be\.com/(watch\?([\w=%&-]*&)?v=|shorts/|live/|embed/)|\.be/)(?P<id>[\w-]{11})`)
+	ytPLRegexp = regexp.MustCompile(`youtube\.com/playlist\?list=(?P<id>[\w-]+)`)
 )
 
 type githubRepoJSON struct {
@@ -111,13 +129,13 @@ Return:
 */
 var parseMap = []struct {
 	re *regexp.Regexp
-	fn func(*regexp.Regexp, string) (string, error)
+	fn func(*Module, *regexp.Regexp, string) (string, error)
 }{
-	{ytVidRegexp, ytVidParser},
-	{ytPLRegexp, ytPLParser},
-	{githubRegexp, githubParser},
-	{githubIORegexp, githubParser},
-	{vimeoRegexp, vimeoParser},
-	{steamRegexp, steamParser},
-	{hnRegexp, hnParser},
+	{ytVidRegexp, (*Module).ytVidParser},
+	{ytPLRegexp, (*Module).ytPLParser},
+	{githubRegexp, (*Module).githubParser},
+	{githubIORegexp, (*Module).githubParser},
+	{vimeoRegexp, (*Module).vimeoParser},
+	{steamRegexp, (*Module).steamParser},
+	{hnRegexp, (*Module).hnParser},
 }
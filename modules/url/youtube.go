@@ -0,0 +1,271 @@
+package url
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// PT1H2M3S, and P1DT2H for streams longer than a day
+	isoDurationRe = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+	// t=90, t=90s, t=1m30s, t=1h2m3s
+	timestampRe = regexp.MustCompile(`^(?:(\d+)h)?(?:(\d+)m)?(?:(\d+)s?)?$`)
+)
+
+// youtubeClient talks to the YouTube Data API v3 through fetch. Without a key
+// there is no client and YouTube links are left to the generic title fallback
+type youtubeClient struct {
+	Key string
+	Api string // Base URL, without a trailing slash
+
+	fetch *fetcher
+}
+
+// youtubeConfig is the toml configuration of a youtubeClient
+type youtubeConfig struct {
+	Key string `toml:"key"` // YouTube Data API key
+	Api string `toml:"api"` // Base URL, defaults to youtubeAPI
+}
+
+// ytVideo is a video as returned by videos.list
+type ytVideo struct {
+	Id      string `json:"id"`
+	Snippet struct {
+		Title                string `json:"title"`
+		ChannelTitle         string `json:"channelTitle"`
+		LiveBroadcastContent string `json:"liveBroadcastContent"` // live, upcoming or none
+	} `json:"snippet"`
+	ContentDetails struct {
+		Duration      string `json:"duration"` // ISO-8601
+		ContentRating struct {
+			YtRating string `json:"ytRating"`
+		} `json:"contentRating"`
+	} `json:"contentDetails"`
+	Statistics struct {
+		ViewCount string `json:"viewCount"`
+		LikeCount string `json:"likeCount"` // Missing when likes are hidden
+	} `json:"statistics"`
+	LiveStreamingDetails struct {
+		ScheduledStartTime time.Time `json:"scheduledStartTime"`
+		ConcurrentViewers  string    `json:"concurrentViewers"`
+	} `json:"liveStreamingDetails"`
+}
+
+// ytPlaylist is a playlist as returned by playlists.list
+type ytPlaylist struct {
+	Id      string `json:"id"`
+	Snippet struct {
+		Title        string `json:"title"`
+		ChannelTitle string `json:"channelTitle"`
+	} `json:"snippet"`
+	ContentDetails struct {
+		ItemCount int `json:"itemCount"`
+	} `json:"contentDetails"`
+}
+
+func newYoutubeClient(conf youtubeConfig, fetch *fetcher) *youtubeClient {
+	api := conf.Api
+	if api == "" {
+		api = youtubeAPI
+	}
+
+	return &youtubeClient{
+		Key: conf.Key,
+		Api: strings.TrimRight(api, "/"),
+
+		fetch: fetch,
+	}
+}
+
+// Video() looks up the video id
+func (self *youtubeClient) Video(id string) (*ytVideo, error) {
+	resp := struct {
+		Items []ytVideo `json:"items"`
+	}{}
+
+	err := self.get("/videos", url.Values{
+		"id":   {id},
+		"part": {"snippet,contentDetails,statistics,liveStreamingDetails"},
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Items) == 0 {
+		return nil, fmt.Errorf("YouTube has no video %v", id)
+	}
+
+	return &resp.Items[0], nil
+}
+
+// Playlist() looks up the playlist id
+func (self *youtubeClient) Playlist(id string) (*ytPlaylist, error) {
+	resp := struct {
+		Items []ytPlaylist `json:"items"`
+	}{}
+
+	err := self.get("/playlists", url.Values{
+		"id":   {id},
+		"part": {"snippet,contentDetails"},
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Items) == 0 {
+		return nil, fmt.Errorf("YouTube has no playlist %v", id)
+	}
+
+	return &resp.Items[0], nil
+}
+
+func (self *youtubeClient) get(method string, query url.Values, v interface{}) error {
+	query.Set("key", self.Key)
+
+	err := self.fetch.JSON(self.Api+method+"?"+query.Encode(), v)
+	if urlErr, ok := err.(*url.Error); ok {
+		// Keep the key out of logs and the cache
+		urlErr.URL = self.Api + method
+	}
+
+	return err
+}
+
+// Status() describes the video's length, or its stream or premiere
+func (self *ytVideo) Status(now time.Time) string {
+	live := &self.LiveStreamingDetails
+
+	switch self.Snippet.LiveBroadcastContent {
+	case "live":
+		if live.ConcurrentViewers != "" {
+			return fmt.Sprintf("LIVE, %v watching", commas(live.ConcurrentViewers))
+		}
+
+		return "LIVE"
+	case "upcoming":
+		// Premieres are uploads, so unlike streams they already have a length
+		kind := "live"
+		if d, err := parseISODuration(self.ContentDetails.Duration); err == nil && d > 0 {
+			kind = "premieres"
+		}
+
+		if live.ScheduledStartTime.IsZero() {
+			return kind + " soon"
+		}
+
+		return fmt.Sprintf("%v in %v", kind, roundDuration(live.ScheduledStartTime.Sub(now)))
+	}
+
+	d, err := parseISODuration(self.ContentDetails.Duration)
+	if err != nil {
+		return "?"
+	}
+
+	return d.String()
+}
+
+// AgeRestricted() reports whether YouTube requires sign-in to watch
+func (self *ytVideo) AgeRestricted() bool {
+	return self.ContentDetails.ContentRating.YtRating == "ytAgeRestricted"
+}
+
+// parseISODuration() parses the ISO-8601 durations the API returns
+func parseISODuration(s string) (time.Duration, error) {
+	res := isoDurationRe.FindStringSubmatch(s)
+	if res == nil {
+		return 0, fmt.Errorf("Bad ISO-8601 duration %q", s)
+	}
+
+	units := []time.Duration{time.Hour * 24, time.Hour, time.Minute, time.Second}
+	d := time.Duration(0)
+
+	for i, unit := range units {
+		if res[i+1] == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(res[i+1])
+		if err != nil {
+			return 0, err
+		}
+
+		d += time.Duration(n) * unit
+	}
+
+	return d, nil
+}
+
+// parseTimestamp() returns the seconds in a t= or start= parameter
+func parseTimestamp(s string) (int, bool) {
+	res := timestampRe.FindStringSubmatch(strings.ToLower(s))
+	if res == nil || s == "" {
+		return 0, false
+	}
+
+	secs := 0
+
+	for i, unit := range []int{3600, 60, 1} {
+		if res[i+1] == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(res[i+1])
+		if err != nil {
+			return 0, false
+		}
+
+		secs += n * unit
+	}
+
+	return secs, true
+}
+
+// ytTimestamp() returns the seconds into the video uri links to, from t= or
+// start= in its query or fragment
+func ytTimestamp(uri string) (int, bool) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return 0, false
+	}
+
+	fragment, _ := url.ParseQuery(u.Fragment)
+
+	for _, vals := range []url.Values{u.Query(), fragment} {
+		for _, key := range []string{"t", "start"} {
+			if secs, ok := parseTimestamp(vals.Get(key)); ok {
+				return secs, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// roundDuration() drops the precision countdowns do not need
+func roundDuration(d time.Duration) time.Duration {
+	switch {
+	case d < 0:
+		return 0
+	case d >= time.Hour:
+		return d / time.Minute * time.Minute
+	}
+
+	return d / time.Second * time.Second
+}
+
+// commas() groups the digits of a decimal count in threes
+func commas(n string) string {
+	if _, err := strconv.ParseUint(n, 10, 64); err != nil {
+		return n
+	}
+
+	for i := len(n) - 3; i > 0; i -= 3 {
+		n = n[:i] + "," + n[i:]
+	}
+
+	return n
+}
@@ -0,0 +1,240 @@
+package url
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crimsonvoid/ayuko/harness"
+)
+
+const testYtKey = "sekrit"
+
+// ytStart is when the upcoming test videos are scheduled to start
+var ytStart = time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// ytVideos are the videos ytServer() knows, as videos.list items
+var ytVideos = map[string]string{
+	"streamLive1": `{"id":"streamLive1","snippet":{"liveBroadcastContent":"live"},` +
+		`"contentDetails":{"duration":"P0D"},"liveStreamingDetails":{"concurrentViewers":"12345"}}`,
+	"streamSoon1": `{"id":"streamSoon1","snippet":{"liveBroadcastContent":"upcoming"},` +
+		`"contentDetails":{"duration":"P0D"},"liveStreamingDetails":{"scheduledStartTime":"2030-01-01T12:00:00Z"}}`,
+	"premiereSn1": `{"id":"premiereSn1","snippet":{"liveBroadcastContent":"upcoming"},` +
+		`"contentDetails":{"duration":"PT3M30S"},"liveStreamingDetails":{"scheduledStartTime":"2030-01-01T12:00:00Z"}}`,
+	"restricted1": `{"id":"restricted1","snippet":{"liveBroadcastContent":"none"},` +
+		`"contentDetails":{"duration":"PT1H2M3S","contentRating":{"ytRating":"ytAgeRestricted"}}}`,
+}
+
+// ytServer() stands in for the YouTube Data API, serving ytVideos
+func ytServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("key") != testYtKey {
+			http.Error(w, "bad key", http.StatusForbidden)
+
+			return
+		}
+		if r.URL.Path != "/videos" {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		items := []string{}
+		if video, ok := ytVideos[r.URL.Query().Get("id")]; ok {
+			items = append(items, video)
+		}
+
+		fmt.Fprintf(w, `{"items":[%v]}`, strings.Join(items, ","))
+	}))
+}
+
+// newTestYoutube() creates a client whose fetches all go to srv
+func newTestYoutube(t *testing.T, srv *httptest.Server) *youtubeClient {
+	fetch, err := newFetcher(fetchConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetch.client.Transport = &http.Transport{
+		Dial: func(network, _ string) (net.Conn, error) {
+			return net.Dial(network, srv.Listener.Addr().String())
+		},
+	}
+
+	return newYoutubeClient(youtubeConfig{Key: testYtKey, Api: srv.URL}, fetch)
+}
+
+func TestParseISODuration(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"PT1H2M3S": time.Hour + time.Minute*2 + time.Second*3,
+		"PT45S":    time.Second * 45,
+		"PT10M":    time.Minute * 10,
+		"P1DT2H":   time.Hour * 26,
+		"P0D":      0,
+	} {
+		if got, err := parseISODuration(s); err != nil || got != want {
+			t.Errorf("parseISODuration(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+
+	for _, s := range []string{"", "1H", "PT1X", "PT-1S"} {
+		if got, err := parseISODuration(s); err == nil {
+			t.Errorf("parseISODuration(%q) = %v; want an error", s, got)
+		}
+	}
+}
+
+func TestYtTimestamp(t *testing.T) {
+	for uri, want := range map[string]int{
+		"https://youtu.be/dQw4w9WgXcQ?t=90":                     90,
+		"https://youtu.be/dQw4w9WgXcQ?t=90s":                    90,
+		"https://youtube.com/watch?v=dQw4w9WgXcQ&t=1m30s":       90,
+		"https://youtube.com/watch?v=dQw4w9WgXcQ&t=1h2m3s":      3723,
+		"https://youtube.com/embed/dQw4w9WgXcQ?start=42":        42,
+		"https://youtube.com/watch?v=dQw4w9WgXcQ#t=2m":          120,
+		"https://youtube.com/watch?v=dQw4w9WgXcQ#a=1&start=10s": 10,
+	} {
+		if got, ok := ytTimestamp(uri); !ok || got != want {
+			t.Errorf("ytTimestamp(%q) = %v, %v; want %v", uri, got, ok, want)
+		}
+	}
+
+	for _, uri := range []string{
+		"https://youtu.be/dQw4w9WgXcQ",
+		"https://youtu.be/dQw4w9WgXcQ?t=soon",
+		"https://youtu.be/dQw4w9WgXcQ?t=",
+	} {
+		if got, ok := ytTimestamp(uri); ok {
+			t.Errorf("ytTimestamp(%q) = %v; want no timestamp", uri, got)
+		}
+	}
+}
+
+func TestYtStatus(t *testing.T) {
+	srv := ytServer()
+	defer srv.Close()
+
+	yt := newTestYoutube(t, srv)
+	now := ytStart.Add(-time.Minute * 90)
+
+	for id, want := range map[string]string{
+		"streamLive1": "LIVE, 12,345 watching",
+		"streamSoon1": "live in 1h30m0s",
+		"premiereSn1": "premieres in 1h30m0s",
+		"restricted1": "1h2m3s",
+	} {
+		video, err := yt.Video(id)
+		if err != nil {
+			t.Errorf("Video(%v) %v", id, err)
+
+			continue
+		}
+		if got := video.Status(now); got != want {
+			t.Errorf("Video(%v).Status() = %q; want %q", id, got, want)
+		}
+	}
+}
+
+func TestYtAgeRestricted(t *testing.T) {
+	srv := ytServer()
+	defer srv.Close()
+
+	yt := newTestYoutube(t, srv)
+
+	for id, want := range map[string]bool{
+		"restricted1": true,
+		"streamLive1": false,
+	} {
+		video, err := yt.Video(id)
+		if err != nil {
+			t.Errorf("Video(%v) %v", id, err)
+
+			continue
+		}
+		if got := video.AgeRestricted(); got != want {
+			t.Errorf("Video(%v).AgeRestricted() = %v; want %v", id, got, want)
+		}
+	}
+
+	if _, err := yt.Video("missingVid1"); err == nil {
+		t.Error("Video() of an unknown id succeeded")
+	}
+}
+
+func TestYtVidRegexp(t *testing.T) {
+	for _, uri := range []string{
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ",
+		"https://youtube.com/watch?feature=share&v=dQw4w9WgXcQ",
+		"https://youtu.be/dQw4w9WgXcQ?si=abc",
+		"https://youtube.com/shorts/dQw4w9WgXcQ",
+		"https://m.youtube.com/shorts/dQw4w9WgXcQ?feature=share",
+		"https://www.youtube.com/live/dQw4w9WgXcQ?si=abc",
+		"https://www.youtube.com/embed/dQw4w9WgXcQ",
+	} {
+		groups, err := matchGroups(ytVidRegexp, uri)
+		if err != nil || groups["id"] != "dQw4w9WgXcQ" {
+			t.Errorf("ytVidRegexp on %q = %v, %v; want id dQw4w9WgXcQ", uri, groups, err)
+		}
+	}
+
+	for _, uri := range []string{
+		"https://youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw",
+		"https://youtube.com/playlist?list=PLx0sYbCqOb8TBPRdmBHs5Iftvv9TPboYG",
+		"https://example.com/shorts/dQw4w9WgXcQ",
+	} {
+		if ytVidRegexp.MatchString(uri) {
+			t.Errorf("ytVidRegexp matched %q", uri)
+		}
+	}
+}
+
+func TestYoutubeErrorHidesKey(t *testing.T) {
+	srv := ytServer()
+	srv.Close() // Refuse connections
+
+	_, err := newTestYoutube(t, srv).Video("streamLive1")
+	if err == nil {
+		t.Fatal("Video() against a closed server succeeded")
+	}
+	if strings.Contains(err.Error(), testYtKey) {
+		t.Errorf("Video() error reveals the key: %v", err)
+	}
+}
+
+func TestYoutubeShortsPreview(t *testing.T) {
+	srv := ytServer()
+	defer srv.Close()
+
+	bot := startUrl(t, fmt.Sprintf(`
+[url.youtube]
+key = %q
+api = %q
+`, testYtKey, srv.URL), srv)
+
+	msgs, err := bot.Say("alice", harness.Channel, "https://youtube.com/shorts/restricted1?feature=share", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || !strings.Contains(msgs[0].Text, "https://youtu.be/restricted1]") ||
+		!strings.Contains(msgs[0].Text, "1h2m3s") || !strings.Contains(msgs[0].Text, "18+") {
+		t.Errorf("got %v; want the video's link, length and age restriction", msgs)
+	}
+}
+
+func TestYoutubeWithoutKey(t *testing.T) {
+	srv := pageServer()
+	defer srv.Close()
+
+	bot := startUrl(t, "", srv)
+
+	msgs, err := bot.Say("alice", harness.Channel, "http://www.youtube.com/watch?v=dQw4w9WgXcQ", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || !strings.Contains(msgs[0].Text, "Page at www.youtube.com/watch") {
+		t.Errorf("got %v; want the generic title", msgs)
+	}
+}
@@ -12,14 +12,18 @@ const (
 	timeoutMax = 60 // minutes
 
 	timeoutResetFact = 1.5
+
+	zenAPI = "https://api.github.com/zen"
 )
 
-var (
-	timeoutMult = timeoutMin
-	lastTimeout = time.Now()
+// Module is one instance of the zen module
+type Module struct {
+	*module.Module
 
-	zenChan = make(chan string, 10)
-	quit    = make(chan bool)
+	api         string // Where zen is fetched from
+	timeoutMult int
+	lastTimeout time.Time
 
-	Module *module.Module
-)
+	zenChan chan string
+	quit    chan bool
+}
@@ -5,8 +5,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"path/filepath"
-	"runtime"
 	"strings"
 	"time"
 
@@ -14,59 +12,70 @@ import (
 	irc "github.com/fluffle/goirc/client"
 )
 
-func init() {
-	_, file, _, _ := runtime.Caller(0)
-	base := filepath.Base(file)
-	ext := filepath.Ext(base)
-	var err error
-
-	Module, err = module.New(fmt.Sprintf("data%[1]cconfs%[1]c%v.toml",
-		filepath.Separator, base[:len(base)-len(ext)]))
+// New() creates a zen module configured by confFile
+func New(confFile string) (*Module, error) {
+	mod, err := module.New(confFile)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	Module.Preconnect = func() error {
-		go preConnect()
+	self := &Module{
+		Module: mod,
+
+		api:         zenAPI,
+		timeoutMult: timeoutMin,
+		lastTimeout: time.Now(),
+
+		zenChan: make(chan string, 10),
+		quit:    make(chan bool),
+	}
+	self.registerCommands()
+
+	return self, nil
+}
+
+func (self *Module) registerCommands() {
+	self.Preconnect = func() error {
+		go self.preConnect()
 
 		return nil
 	}
 
-	Module.Disconnect = func() error {
-		quit <- true
+	self.Disconnect = func() error {
+		self.quit <- true
 
 		return nil
 	}
 
-	Module.Register(module.E_PRIVMSG, ".zen", func(line *irc.Line) {
+	self.Register(module.E_PRIVMSG, ".zen", func(line *irc.Line) {
 		var zen string
 
 		select {
-		case zen = <-zenChan:
+		case zen = <-self.zenChan:
 		case <-time.After(time.Second * 10):
 			zen = "Timeout while waiting for zen"
 		}
 
-		Module.Logger.Infoln(fmt.Sprintf("%s - %s", line.Target(), zen))
-		Module.Conn.Privmsg(line.Target(), zen)
+		self.Logger.Infoln(fmt.Sprintf("%s - %s", line.Target(), zen))
+		self.Conn.Privmsg(line.Target(), zen)
 	})
 }
 
-func preConnect() {
+func (self *Module) preConnect() {
 	abrt := make(chan bool)
 
 	for {
 		// TODO - Potential block
-		resp, err := http.Get("https://api.github.com/zen")
+		resp, err := http.Get(self.api)
 		if err != nil {
-			Module.Logger.Errorln(err)
+			self.Logger.Errorln(err)
 			continue
 		}
 		// sendZen() closes resp.Body
 
 		select {
-		case <-sendZen(resp, abrt): // sendZen || timeout
-		case <-quit:
+		case <-self.sendZen(resp, abrt): // sendZen || timeout
+		case <-self.quit:
 			select {
 			case abrt <- true:
 			case <-time.After(time.Second):
@@ -77,7 +86,7 @@ func preConnect() {
 	}
 }
 
-func sendZen(resp *http.Response, abrt <-chan bool) <-chan error {
+func (self *Module) sendZen(resp *http.Response, abrt <-chan bool) <-chan error {
 	errCh := make(chan error)
 
 	go func() {
@@ -85,7 +94,7 @@ func sendZen(resp *http.Response, abrt <-chan bool) <-chan error {
 
 		if err := respStatus(resp); err != nil {
 			select {
-			case <-time.After(time.Duration(int(time.Minute) * timeoutMult)):
+			case <-time.After(time.Duration(int(time.Minute) * self.timeoutMult)):
 				select {
 				case errCh <- err:
 				case <-abrt:
@@ -110,11 +119,11 @@ func sendZen(resp *http.Response, abrt <-chan bool) <-chan error {
 		}
 
 		select {
-		case zenChan <- string(zen):
-			dur := int(float64(int(time.Minute)*timeoutMult) * timeoutResetFact)
-			if time.Now().Sub(lastTimeout) > time.Duration(dur) {
-				if timeoutMult -= timeoutInc; timeoutMult < timeoutMin {
-					timeoutMult = timeoutMin
+		case self.zenChan <- string(zen):
+			dur := int(float64(int(time.Minute)*self.timeoutMult) * timeoutResetFact)
+			if time.Now().Sub(self.lastTimeout) > time.Duration(dur) {
+				if self.timeoutMult -= timeoutInc; self.timeoutMult < timeoutMin {
+					self.timeoutMult = timeoutMin
 				}
 			}
 		case <-abrt:
@@ -0,0 +1,34 @@
+package zen
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/crimsonvoid/ayuko/harness"
+)
+
+func TestZen(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "Keep it logically awesome.")
+	}))
+	defer srv.Close()
+
+	mod, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod.api = srv.URL
+
+	bot := harness.StartModule(t, mod.Module)
+
+	msgs, err := bot.Say("alice", harness.Channel, ".zen", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Target != harness.Channel || msgs[0].Text != "Keep it logically awesome." {
+		t.Errorf("got %v; want the zen in %v", msgs, harness.Channel)
+	}
+}
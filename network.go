@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/crimsonvoid/irclib"
+	"github.com/crimsonvoid/irclib/module"
+	irc "github.com/fluffle/goirc/client"
+
+	"github.com/CrimsonVoid/ayuko/modules/choices"
+	"github.com/CrimsonVoid/ayuko/modules/magicball"
+	"github.com/CrimsonVoid/ayuko/modules/roll"
+	"github.com/crimsonvoid/ayuko/modules/fcode"
+	"github.com/crimsonvoid/ayuko/modules/identity"
+	"github.com/crimsonvoid/ayuko/modules/reminds"
+	"github.com/crimsonvoid/ayuko/modules/url"
+	"github.com/crimsonvoid/ayuko/modules/zen"
+)
+
+// defaultModules are run on networks that do not list their own
+var defaultModules = []string{"identity", "fcode", "reminds", "url", "roll", "magicball", "choices"}
+
+// networksConfig is the networks file:
+//
+//	[[network]]
+//	name = "libera"
+//	config = "data/confs/libera.toml" # irclib config: server, nick and channels
+//	modules = ["identity", "fcode", "reminds"]
+//
+// Module configs are read from data/confs/<name>/ when they exist there, and
+// data/confs/ otherwise. Identities and reminds are kept per network under
+// data/<name>/; friend codes are shared by every network
+type networksConfig struct {
+	Networks []network `toml:"network"`
+}
+
+type network struct {
+	Name    string   `toml:"name"`
+	Config  string   `toml:"config"`
+	Modules []string `toml:"modules"`
+}
+
+// sleep waits between reconnects; tests replace it
+var sleep = time.Sleep
+
+// shared is the state modules on every network share
+type shared struct {
+	fcode *fcode.Module // First network's; later networks Share() it
+}
+
+// loadNetworks() reads the networks file. A missing file is no networks
+func loadNetworks(fileName string) ([]network, error) {
+	conf := networksConfig{}
+
+	if _, err := toml.DecodeFile(fileName, &conf); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	names := make(map[string]bool, len(conf.Networks))
+
+	for _, net := range conf.Networks {
+		switch {
+		case net.Name == "" && len(conf.Networks) > 1:
+			return nil, fmt.Errorf("Every network needs a name when there are several")
+		case names[net.Name]:
+			return nil, fmt.Errorf("Network %v is listed twice", net.Name)
+		case net.Config == "":
+			return nil, fmt.Errorf("Network %v has no config", net.Name)
+		}
+
+		names[net.Name] = true
+	}
+
+	return conf.Networks, nil
+}
+
+// session is a network's manager and connection, as run() drives them
+type session struct {
+	connect   func() error // Runs every module's Preconnect, then connects
+	connected func() bool
+	quit      <-chan bool // true once the manager quits for good
+}
+
+// setup() creates the network's manager and registers its modules. Nothing
+// in shared is changed unless every module is set up
+func (self *network) setup(shared *shared) (*session, error) {
+	m, err := irclib.New(self.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every module is given the manager's connection
+	var conn *irc.Conn
+	var fcodes *fcode.Module
+
+	modules := self.Modules
+	if len(modules) == 0 {
+		modules = defaultModules
+	}
+
+	// Identities go first so the other modules see nicks resolved. Without
+	// them every nick is its own identity
+	var ids *identity.Module
+	for _, name := range modules {
+		if name == "identity" {
+			if ids, err = identity.New(self.conf("identity"), self.data("identity")); err != nil {
+				return nil, err
+			}
+			m.Register(ids.Module)
+			conn = ids.Module.Conn
+		}
+	}
+
+	for _, name := range modules {
+		var mod *module.Module
+
+		switch name {
+		case "identity":
+			continue
+		case "fcode":
+			if shared.fcode == nil {
+				fcodes, err = fcode.New(self.conf("fcode"), filepath.Join(dataDir, "fcode"), ids)
+			} else {
+				fcodes, err = shared.fcode.Share(self.conf("fcode"), ids)
+			}
+			if err == nil {
+				mod = fcodes.Module
+			}
+		case "reminds":
+			var rem *reminds.Module
+			if rem, err = reminds.New(self.conf("rem"), self.data("reminds"), ids); err == nil {
+				mod = rem.Module
+			}
+		case "url":
+			var u *url.Module
+			if u, err = url.New(self.conf("url"), self.data("url")); err == nil {
+				mod = u.Module
+			}
+		case "roll":
+			var r *roll.Module
+			if r, err = roll.New(self.conf("roll")); err == nil {
+				mod = r.Module
+			}
+		case "magicball":
+			var mb *magicball.Module
+			if mb, err = magicball.New(self.conf("magicball")); err == nil {
+				mod = mb.Module
+			}
+		case "choices":
+			var c *choices.Module
+			if c, err = choices.New(self.conf("choices")); err == nil {
+				mod = c.Module
+			}
+		case "zen":
+			var z *zen.Module
+			if z, err = zen.New(self.conf("zen")); err == nil {
+				mod = z.Module
+			}
+		default:
+			err = fmt.Errorf("Unknown module %q", name)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%v on network %v: %v", name, self.Name, err)
+		}
+
+		m.Register(mod)
+		conn = mod.Conn
+	}
+
+	if shared.fcode == nil {
+		shared.fcode = fcodes
+	}
+
+	return &session{
+		connect:   m.Connect,
+		connected: func() bool { return conn != nil && conn.Connected() },
+		quit:      m.Quit,
+	}, nil
+}
+
+// run() connects s and keeps it connected until it quits for good. Failed
+// connections are retried, waiting twice as long each time up to maxBackoff;
+// the wait resets once a connection has lasted maxBackoff.
+//
+// A false quit means the connection dropped. Once the wait is over s is only
+// connected again if it is still down, so a connection irclib restored in
+// the meantime is waited on rather than joined by a second one
+func (self *network) run(s *session) {
+	backoff := minBackoff
+	reconnect := true
+
+	for {
+		if !reconnect {
+			log.Printf("%v is connected again\n", self)
+		} else if err := s.connect(); err != nil {
+			log.Printf("Error connecting to %v: %v. Retrying in %v\n", self, err, backoff)
+
+			backoff = self.wait(backoff)
+
+			continue
+		}
+
+		connected := time.Now()
+		if succExit := <-s.quit; succExit {
+			return
+		}
+
+		if time.Since(connected) >= maxBackoff {
+			backoff = minBackoff
+		}
+		log.Printf("Disconnected from %v. Reconnecting in %v\n", self, backoff)
+
+		backoff = self.wait(backoff)
+		reconnect = !s.connected()
+	}
+}
+
+// wait() sleeps for backoff and returns the next, longer, backoff
+func (self *network) wait(backoff time.Duration) time.Duration {
+	sleep(backoff)
+
+	if backoff *= 2; backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	return backoff
+}
+
+func (self *network) String() string {
+	if self.Name == "" {
+		return self.Config
+	}
+
+	return self.Name
+}
+
+// conf() returns the config file of the module name on this network
+func (self *network) conf(name string) string {
+	fileName := filepath.Join(confDir, self.Name, name+".toml")
+	if _, err := os.Stat(fileName); err != nil {
+		return filepath.Join(confDir, name+".toml")
+	}
+
+	return fileName
+}
+
+// data() returns the data directory of the module name on this network
+func (self *network) data(name string) string {
+	return filepath.Join(dataDir, self.Name, name)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeSession is a session whose connects fail until fails reaches 0, and
+// which reports each of quits in turn once connected
+type fakeSession struct {
+	fails     int
+	connects  int
+	quits     []bool
+	connected []bool // What connected() reports after each false quit
+}
+
+func (self *fakeSession) session() *session {
+	quit := make(chan bool, len(self.quits))
+	for _, q := range self.quits {
+		quit <- q
+	}
+
+	return &session{
+		connect: func() error {
+			self.connects++
+			if self.fails > 0 {
+				self.fails--
+
+				return errors.New("connection refused")
+			}
+
+			return nil
+		},
+		connected: func() bool {
+			up := self.connected[0]
+			self.connected = self.connected[1:]
+
+			return up
+		},
+		quit: quit,
+	}
+}
+
+// runSession() runs fake to completion, returning each wait run() made
+func runSession(t *testing.T, fake *fakeSession) []time.Duration {
+	waits := []time.Duration{}
+
+	sleep = func(d time.Duration) { waits = append(waits, d) }
+	defer func() { sleep = time.Sleep }()
+
+	done := make(chan bool)
+	go func() {
+		(&network{Name: "test"}).run(fake.session())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("run() did not return")
+	}
+
+	return waits
+}
+
+func TestRunBacksOffConnects(t *testing.T) {
+	fake := &fakeSession{fails: 8, quits: []bool{true}}
+
+	waits := runSession(t, fake)
+
+	want := []time.Duration{minBackoff, minBackoff * 2, minBackoff * 4, minBackoff * 8,
+		minBackoff * 16, minBackoff * 32, minBackoff * 64, maxBackoff}
+	if !reflect.DeepEqual(waits, want) {
+		t.Errorf("waits = %v; want %v", waits, want)
+	}
+	if fake.connects != 9 {
+		t.Errorf("connected %v times; want 9", fake.connects)
+	}
+}
+
+func TestRunReconnectsAfterDrop(t *testing.T) {
+	fake := &fakeSession{quits: []bool{false, true}, connected: []bool{false}}
+
+	waits := runSession(t, fake)
+
+	if !reflect.DeepEqual(waits, []time.Duration{minBackoff}) {
+		t.Errorf("waits = %v; want one of %v", waits, minBackoff)
+	}
+	if fake.connects != 2 {
+		t.Errorf("connected %v times; want 2", fake.connects)
+	}
+}
+
+func TestRunWaitsOnRestoredConnection(t *testing.T) {
+	fake := &fakeSession{quits: []bool{false, true}, connected: []bool{true}}
+
+	runSession(t, fake)
+
+	if fake.connects != 1 {
+		t.Errorf("connected %v times; want 1, as irclib already reconnected", fake.connects)
+	}
+}